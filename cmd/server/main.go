@@ -2,56 +2,294 @@ package main
 
 import (
 	"context"
+	"net"
+	nethttp "net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/wish"
+	"github.com/pigeonligh/srp/pkg/auth"
+	"github.com/pigeonligh/srp/pkg/http"
+	"github.com/pigeonligh/srp/pkg/nets"
 	"github.com/pigeonligh/srp/pkg/proxy"
 	"github.com/pigeonligh/srp/pkg/proxy/providers"
 	"github.com/pigeonligh/srp/pkg/reverseproxy"
 	"github.com/pigeonligh/srp/pkg/server"
+	"github.com/pigeonligh/srp/pkg/systemd"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+func listen(address string) (net.Listener, error) {
+	listeners, err := systemd.Listeners(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return net.Listen("tcp", address)
+	}
+
+	for _, l := range listeners[1:] {
+		logrus.Warnf("Ignore extra systemd socket %v (%v)", l.Name, l.Addr())
+		_ = l.Close()
+	}
+	logrus.Infof("Use systemd socket %v (%v)", listeners[0].Name, listeners[0].Addr())
+	return listeners[0], nil
+}
+
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		logrus.Warnf("Cannot notify systemd %v: %v", state, err)
+	}
+}
+
+// notifyStopping is sent once, whether the server stops on a signal or on an error.
+var notifyStopping = sync.OnceFunc(func() {
+	notify(systemd.NotifyStopping)
+})
+
 func main() {
 	var name string
 	var address string
 	var socketDir string
 	var hostKey string
+	var passwordFile string
+	var totpDir string
+	var maxAuthFailures int
+	var banUsers bool
+	var authAllowlist []string
+	var mappingFile string
+	var healthCheck string
+	var dialAttempts int
+	var circuitFailures int
+	var passthroughAddress string
+	var multiplex bool
+	var muxCertFile string
+	var muxKeyFile string
+	var wsPath string
+	var wsAddress string
+	var wsCertFile string
+	var wsKeyFile string
+	var wsTrustedProxies []string
 
 	cmd := &cobra.Command{
 		Use: "srp-server",
 		Run: func(cmd *cobra.Command, args []string) {
-			rp, err := reverseproxy.New(nil, nil, socketDir)
+			switch {
+			case wsPath != "" && wsAddress == "" && !multiplex:
+				logrus.Fatalln("Error: --websocket-path requires --websocket-address or --multiplex")
+			case wsPath == "" && (wsAddress != "" || wsCertFile != "" || wsKeyFile != "" || len(wsTrustedProxies) > 0):
+				logrus.Fatalln("Error: --websocket-address, --websocket-cert-file, --websocket-key-file and --websocket-trusted-proxies require --websocket-path")
+			case (wsCertFile == "") != (wsKeyFile == ""):
+				logrus.Fatalln("Error: --websocket-cert-file and --websocket-key-file must be given together")
+			case wsCertFile != "" && wsAddress == "":
+				logrus.Fatalln("Error: --websocket-cert-file requires --websocket-address")
+			case (muxCertFile == "") != (muxKeyFile == ""):
+				logrus.Fatalln("Error: --multiplex-cert-file and --multiplex-key-file must be given together")
+			case muxCertFile != "" && !multiplex:
+				logrus.Fatalln("Error: --multiplex-cert-file requires --multiplex")
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			var authenticator auth.Authenticator
+			if passwordFile != "" {
+				authenticator = auth.UserPasswordAuthenticator(auth.HtpasswdFile(passwordFile))
+			}
+
+			rpOptions := []reverseproxy.Option{
+				reverseproxy.WithAuthenticator(authenticator),
+				reverseproxy.WithUnixDirectory(socketDir),
+			}
+			switch {
+			case healthCheck == "":
+			case healthCheck == "tcp":
+				rpOptions = append(rpOptions, reverseproxy.WithHealthCheck(func(host, port string) *reverseproxy.HealthCheck {
+					return &reverseproxy.HealthCheck{}
+				}))
+			case strings.HasPrefix(healthCheck, "/"):
+				rpOptions = append(rpOptions, reverseproxy.WithHealthCheck(func(host, port string) *reverseproxy.HealthCheck {
+					return &reverseproxy.HealthCheck{HTTPPath: healthCheck}
+				}))
+			default:
+				logrus.Fatalf("Error: invalid health check %q", healthCheck)
+			}
+			rp, err := reverseproxy.NewWithOptions(rpOptions...)
+			if err != nil {
+				logrus.Fatalln("Error:", err)
+			}
+			var provider proxy.ProxyProvider = providers.SocketProvider(rp, 0)
+			if mappingFile != "" {
+				mapping, err := providers.NewMappingFile(ctx, mappingFile, 0)
+				if err != nil {
+					logrus.Fatalln("Error:", err)
+				}
+				provider = proxy.FallbackProxyProvider(provider, mapping)
+			}
+			if circuitFailures > 0 {
+				provider = proxy.ProxyProviderWithCircuitBreaker(provider, &proxy.CircuitBreaker{FailureThreshold: circuitFailures})
+			}
+			if dialAttempts > 1 {
+				provider = proxy.ProxyProviderWithRetry(provider, proxy.RetryPolicy{Attempts: dialAttempts})
+			}
+			p := proxy.NewWithOptions(
+				proxy.WithAuthenticator(authenticator),
+				proxy.WithProxyProvider(provider),
+				proxy.WithCacheEnabled(true),
+				// Published sockets and mappings are the same for every user.
+				proxy.WithSharedCache(true),
+				proxy.WithDialBeforeAccept(true),
+				proxy.WithPacketDialer(rp),
+			)
+
+			l, err := listen(address)
 			if err != nil {
 				logrus.Fatalln("Error:", err)
 			}
-			p := proxy.New(nil, nil, providers.SocketProvider(rp, 0), true)
 
-			s := server.New(
-				name,
+			var mux *nets.Mux
+			if multiplex {
+				mux = nets.NewMux(l)
+				l = mux.Listener(nets.ProtocolSSH)
+			}
+			var wsHandler nethttp.Handler
+			if wsPath != "" {
+				var wsListener net.Listener
+				wsListener, wsHandler = nets.WebSocketListener(l.Addr(), wsTrustedProxies...)
+				l = nets.MergeListeners(l, wsListener)
+			}
+
+			options := []server.Option{
 				server.WithReverseProxy(rp),
 				server.WithProxy(p),
+				server.WithListener(l),
 				server.WithSSHOptions(
 					wish.WithHostKeyPath(hostKey),
-					wish.WithAddress(address),
 				),
-			)
+			}
+			if maxAuthFailures > 0 {
+				options = append(options, server.WithLockout(&auth.Lockout{
+					MaxFailures: maxAuthFailures,
+					Window:      10 * time.Minute,
+					BanDuration: 15 * time.Minute,
+					BaseDelay:   500 * time.Millisecond,
+					MaxDelay:    10 * time.Second,
+					BanUsers:    banUsers,
+					Allowlist:   authAllowlist,
+				}))
+			}
+			if totpDir != "" {
+				options = append(options, server.WithChallengeProvider(
+					server.OTPChallenge(auth.NewTOTP(auth.TOTPSecretsDir(totpDir)), 3),
+				))
+			}
+			s := server.New(name, options...)
 
-			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-			defer cancel()
+			go func() {
+				if err := systemd.RunWatchdog(ctx); err != nil {
+					logrus.Warnf("Systemd watchdog disabled: %v", err)
+				}
+			}()
+			go func() {
+				<-ctx.Done()
+				notifyStopping()
+			}()
+			if passthroughAddress != "" {
+				tp := &http.TLSPassthrough{
+					HTTP:   http.HTTP{Address: passthroughAddress},
+					Dialer: nets.SocketsDialer(rp),
+				}
+				go func() {
+					if err := tp.Run(ctx); err != nil {
+						logrus.Fatalln("Error:", err)
+					}
+				}()
+			}
+			servers := make([]interface{ Run(context.Context) error }, 0)
+			if mux != nil {
+				var handler nethttp.Handler = http.Handler(nil, nets.SocketsDialer(rp))
+				if wsHandler != nil {
+					m := nethttp.NewServeMux()
+					m.Handle(wsPath, wsHandler)
+					m.Handle("/", handler)
+					handler = m
+				}
+				if muxCertFile != "" {
+					servers = append(servers, &http.HTTPS{
+						HTTP:     http.HTTP{Listener: mux.Listener(nets.ProtocolTLS), Handler: handler},
+						CertFile: muxCertFile,
+						KeyFile:  muxKeyFile,
+					})
+				} else {
+					servers = append(servers, &http.TLSPassthrough{
+						HTTP:   http.HTTP{Listener: mux.Listener(nets.ProtocolTLS)},
+						Dialer: nets.SocketsDialer(rp),
+					})
+				}
+				servers = append(servers,
+					&http.HTTP{
+						Listener: mux.Listener(nets.ProtocolHTTP),
+						Handler:  handler,
+					},
+					mux,
+				)
+			}
+			if wsHandler != nil && wsAddress != "" {
+				m := nethttp.NewServeMux()
+				m.Handle(wsPath, wsHandler)
+				if wsCertFile != "" {
+					servers = append(servers, &http.HTTPS{
+						HTTP:     http.HTTP{Address: wsAddress, Handler: m},
+						CertFile: wsCertFile,
+						KeyFile:  wsKeyFile,
+					})
+				} else {
+					servers = append(servers, &http.HTTP{Address: wsAddress, Handler: m})
+				}
+			}
+			for _, srv := range servers {
+				go func() {
+					if err := srv.Run(ctx); err != nil {
+						logrus.Fatalln("Error:", err)
+					}
+				}()
+			}
+			notify(systemd.NotifyReady)
 
 			if err := s.Run(ctx); err != nil {
+				notifyStopping()
 				logrus.Fatalln("Error:", err)
 			}
 		},
 	}
 	cmd.Flags().StringVarP(&name, "name", "n", "SRP", "SRP Server Name")
-	cmd.Flags().StringVarP(&address, "address", "a", "127.0.0.1:22", "SRP listen address")
+	cmd.Flags().StringVarP(&address, "address", "a", "127.0.0.1:22", "SRP listen address (ignored under systemd socket activation)")
 	cmd.Flags().StringVarP(&socketDir, "socket-dir", "d", "", "Path for unix socket files")
 	cmd.Flags().StringVarP(&hostKey, "host-key", "k", "ssh_host_ed25519_key", "Host Key File for SSH Server")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "p", "", "htpasswd file for password authentication")
+	cmd.Flags().StringVar(&totpDir, "totp-dir", "", "Directory of per-user TOTP secrets; enrolled users must enter a code after logging in")
+	cmd.Flags().IntVar(&maxAuthFailures, "max-auth-failures", 0, "Ban a client IP for a while after this many failed logins, 0 to disable. Behind a proxy or load balancer, clients share its IP")
+	cmd.Flags().BoolVar(&banUsers, "ban-users", false, "Also ban users after too many failed password or second factor logins; their public keys keep working")
+	cmd.Flags().StringSliceVar(&authAllowlist, "auth-allowlist", nil, "IPs or CIDRs never delayed or banned after failed logins")
+	cmd.Flags().StringVar(&mappingFile, "mapping-file", "", "File of static \"pattern -> address\" target mappings, used when no client publishes the target")
+	cmd.Flags().StringVar(&healthCheck, "health-check", "", "Check published targets with \"tcp\" connects or HTTP GETs of a path like \"/healthz\"")
+	cmd.Flags().IntVar(&dialAttempts, "dial-attempts", 1, "Dial a target up to this many times for a channel, backing off between attempts")
+	cmd.Flags().IntVar(&circuitFailures, "circuit-failures", 0, "Reject channels to a target for 30s after this many dial failures in a row, 0 to disable")
+	cmd.Flags().StringVar(&passthroughAddress, "tls-passthrough-address", "", "Also pass TLS connections through to the target named by their SNI, e.g. \":443\" reaches /example.com/443")
+	cmd.Flags().BoolVar(&multiplex, "multiplex", false, "Also serve TLS passthrough and HTTP, routed by host to published targets, on the SSH listen address")
+	cmd.Flags().StringVar(&muxCertFile, "multiplex-cert-file", "", "Certificate file to serve HTTPS, including SSH over WebSocket, instead of TLS passthrough on the multiplexed port")
+	cmd.Flags().StringVar(&muxKeyFile, "multiplex-key-file", "", "Key file to serve HTTPS instead of TLS passthrough on the multiplexed port")
+	cmd.Flags().StringVar(&wsPath, "websocket-path", "", "Also accept SSH over WebSocket at this HTTP path, e.g. \"/ssh\", on --websocket-address and the multiplexed port")
+	cmd.Flags().StringVar(&wsAddress, "websocket-address", "", "HTTP listen address for SSH over WebSocket")
+	cmd.Flags().StringVar(&wsCertFile, "websocket-cert-file", "", "Certificate file to serve SSH over WebSocket with HTTPS")
+	cmd.Flags().StringVar(&wsKeyFile, "websocket-key-file", "", "Key file to serve SSH over WebSocket with HTTPS")
+	cmd.Flags().StringSliceVar(&wsTrustedProxies, "websocket-trusted-proxies", nil, "IPs or CIDRs of proxies or load balancers in front of SSH over WebSocket; clients are identified by the X-Forwarded-For address they add, e.g. for --max-auth-failures. Otherwise clients behind them share their IP")
+	cmd.AddCommand(newPasswdCommand())
 
 	_ = cmd.Execute()
 }
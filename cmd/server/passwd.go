@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/pigeonligh/srp/pkg/auth"
+	"github.com/spf13/cobra"
+)
+
+func readPassword(fromStdin bool) (string, error) {
+	fd := os.Stdin.Fd()
+	if fromStdin || !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "New password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	fmt.Fprint(os.Stderr, "Re-type new password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("password verification error")
+	}
+	return string(password), nil
+}
+
+func newPasswdCommand() *cobra.Command {
+	var algorithm string
+	var fromStdin bool
+
+	cmd := &cobra.Command{
+		Use:   "passwd FILE USER",
+		Short: "Add or update a user in a htpasswd file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, user := args[0], args[1]
+
+			password, err := readPassword(fromStdin)
+			if err != nil {
+				return err
+			}
+			if password == "" {
+				return fmt.Errorf("empty password")
+			}
+			hashed, err := auth.HashPassword(auth.PasswordHashAlgorithm(algorithm), password)
+			if err != nil {
+				return err
+			}
+			if err := auth.HtpasswdFile(file).SetPassword(user, hashed); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Password for user %v updated.\n", user)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&algorithm, "algorithm", "A", string(auth.PasswordHashBcrypt), "Hash algorithm: bcrypt, argon2id, sha256 or sha512")
+	cmd.Flags().BoolVar(&fromStdin, "stdin", false, "Read the password from stdin")
+	return cmd
+}
@@ -22,17 +22,26 @@ var (
 )
 
 func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
 	rp, err := reverseproxy.New(
-		auth.UserPublicKeysAuthenticator(auth.PublicKeysDir("examples/auth/reverseproxy_auth")),
-		auth.UserGlobsAuthorizer(auth.UserGlobsDir("examples/auth/reverseproxy_rules")),
+		auth.UserPublicKeysAuthenticator(auth.NewCachedPublicKeysDir(ctx, "examples/auth/reverseproxy_auth", 0)),
+		auth.RequireAuthorizers(
+			auth.PermitListenAuthorizer(),
+			auth.UserGlobsAuthorizer(auth.NewCachedUserGlobsDir(ctx, "examples/auth/reverseproxy_rules", 0)),
+		),
 		"",
 	)
 	if err != nil {
 		logrus.Fatalln("Error:", err)
 	}
 	p := proxy.New(
-		auth.UserPublicKeysAuthenticator(auth.PublicKeysDir("examples/auth/proxy_auth")),
-		auth.UserGlobsAuthorizer(auth.UserGlobsDir("examples/auth/proxy_rules")),
+		auth.UserPublicKeysAuthenticator(auth.NewCachedPublicKeysDir(ctx, "examples/auth/proxy_auth", 0)),
+		auth.RequireAuthorizers(
+			auth.PermitOpenAuthorizer(),
+			auth.UserGlobsAuthorizer(auth.NewCachedUserGlobsDir(ctx, "examples/auth/proxy_rules", 0)),
+		),
 		providers.SocketProvider(rp, 0),
 		true,
 	)
@@ -47,9 +56,6 @@ func main() {
 		),
 	)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
-
 	if err := s.Run(ctx); err != nil {
 		logrus.Fatalln("Error:", err)
 	}
@@ -1,12 +1,122 @@
 package auth
 
-import "context"
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
 
 // req
 
+type Action string
+
+const (
+	ActionPublish Action = "publish" // reverse proxy a target
+	ActionConnect Action = "connect" // proxy to a target
+)
+
 type AuthorizeRequest struct {
 	User   string
 	Target string
+	Action Action
+
+	SessionID  string
+	RemoteAddr net.Addr
+
+	KeyFingerprint string   // SHA256 fingerprint, empty for password authentication
+	CertPrincipals []string // set when authenticated with a certificate
+
+	OriginatorAddress string // reported by the client for connect, may be empty
+}
+
+// NewAuthorizeRequest fills the connection details of ctx into a request.
+func NewAuthorizeRequest(ctx ssh.Context, target string, action Action) AuthorizeRequest {
+	req := AuthorizeRequest{
+		User:       ctx.User(),
+		Target:     target,
+		Action:     action,
+		SessionID:  ctx.SessionID(),
+		RemoteAddr: ctx.RemoteAddr(),
+	}
+
+	if key, ok := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey); ok && key != nil {
+		if cert, ok := key.(*gossh.Certificate); ok {
+			req.KeyFingerprint = gossh.FingerprintSHA256(cert.Key)
+			req.CertPrincipals = cert.ValidPrincipals
+		} else {
+			req.KeyFingerprint = gossh.FingerprintSHA256(key)
+		}
+	}
+	return req
+}
+
+// expiry
+
+type expiryContextKey struct{}
+
+type expiry struct {
+	t         time.Time
+	decisions []func(allowed bool)
+	mu        sync.Mutex
+}
+
+// WithExpiry returns a context to authorize with, and a function reporting when
+// the authorized access ends according to LimitExpiry. It reports zero when access does not end.
+// Callers report the final decision with Decide.
+func WithExpiry(ctx context.Context) (context.Context, func() time.Time) {
+	e := &expiry{}
+	return context.WithValue(ctx, expiryContextKey{}, e), func() time.Time {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.t
+	}
+}
+
+// LimitExpiry is called by authorizers whose approval lasts only until t.
+func LimitExpiry(ctx context.Context, t time.Time) {
+	e, ok := ctx.Value(expiryContextKey{}).(*expiry)
+	if !ok || t.IsZero() {
+		return
+	}
+	e.mu.Lock()
+	if e.t.IsZero() || t.Before(e.t) {
+		e.t = t
+	}
+	e.mu.Unlock()
+}
+
+// OnDecision lets authorizers count only what the whole chain allows: fn gets the final decision
+// from Decide. It reports false when ctx does not come from WithExpiry, so nobody reports it.
+func OnDecision(ctx context.Context, fn func(allowed bool)) bool {
+	e, ok := ctx.Value(expiryContextKey{}).(*expiry)
+	if !ok {
+		return false
+	}
+	e.mu.Lock()
+	e.decisions = append(e.decisions, fn)
+	e.mu.Unlock()
+	return true
+}
+
+// Decide reports the final decision on a request authorized with ctx from WithExpiry.
+// It reports whether an authorizer asked for it, i.e. whether the decision must not be reused.
+func Decide(ctx context.Context, allowed bool) bool {
+	e, ok := ctx.Value(expiryContextKey{}).(*expiry)
+	if !ok {
+		return false
+	}
+	e.mu.Lock()
+	decisions := e.decisions
+	e.decisions = nil
+	e.mu.Unlock()
+	for _, fn := range decisions {
+		fn(allowed)
+	}
+	return len(decisions) > 0
 }
 
 // def
@@ -39,3 +149,20 @@ func (slice Authorizers) Authorize(ctx context.Context, req AuthorizeRequest) bo
 func MergeAuthorizers(slice ...Authorizer) Authorizer {
 	return Authorizers(slice)
 }
+
+// all
+
+type AllAuthorizers []Authorizer
+
+func (slice AllAuthorizers) Authorize(ctx context.Context, req AuthorizeRequest) bool {
+	for _, a := range slice {
+		if !a.Authorize(ctx, req) {
+			return false
+		}
+	}
+	return true
+}
+
+func RequireAuthorizers(slice ...Authorizer) Authorizer {
+	return AllAuthorizers(slice)
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// authorized_keys options: https://man.openbsd.org/sshd#AUTHORIZED_KEYS_FILE_FORMAT
+
+// KeyOptions holds the authorized_keys options that SRP enforces.
+// Options about sessions (pty, X11, agent, command, ...) are accepted and ignored,
+// since SRP never runs them.
+type KeyOptions struct {
+	From             []string
+	ExpiryTime       time.Time
+	PermitOpen       []string
+	PermitListen     []string
+	NoPortForwarding bool
+	CertAuthority    bool
+}
+
+var ignoredKeyOptions = map[string]bool{
+	"agent-forwarding":    true,
+	"command":             true,
+	"environment":         true,
+	"no-agent-forwarding": true,
+	"no-pty":              true,
+	"no-touch-required":   true,
+	"no-user-rc":          true,
+	"no-x11-forwarding":   true,
+	"principals":          true,
+	"pty":                 true,
+	"touch-required":      true,
+	"tunnel":              true,
+	"user-rc":             true,
+	"verify-required":     true,
+	"x11-forwarding":      true,
+}
+
+func ParseKeyOptions(options []string) (*KeyOptions, error) {
+	ret := &KeyOptions{}
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		name = strings.ToLower(name)
+		if hasValue {
+			value = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+		}
+
+		switch name {
+		case "restrict", "no-port-forwarding":
+			ret.NoPortForwarding = true
+
+		case "port-forwarding":
+			ret.NoPortForwarding = false
+
+		case "cert-authority":
+			ret.CertAuthority = true
+
+		case "from":
+			if ret.From == nil {
+				ret.From = strings.Split(value, ",")
+			}
+
+		case "expiry-time":
+			t, err := parseExpiryTime(value)
+			if err != nil {
+				return nil, err
+			}
+			if ret.ExpiryTime.IsZero() || t.Before(ret.ExpiryTime) {
+				ret.ExpiryTime = t
+			}
+
+		case "permitopen":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return nil, fmt.Errorf("invalid permitopen %q: %w", value, err)
+			}
+			ret.PermitOpen = append(ret.PermitOpen, value)
+
+		case "permitlisten":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				value = net.JoinHostPort("*", value)
+			}
+			ret.PermitListen = append(ret.PermitListen, value)
+
+		default:
+			if !ignoredKeyOptions[name] {
+				return nil, fmt.Errorf("unsupported option %q", name)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func parseExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		value = value[:len(value)-1]
+		loc = time.UTC
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("invalid expiry-time %q", value)
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// Allowed checks from= and expiry-time against a new connection.
+func (o *KeyOptions) Allowed(remoteAddr net.Addr, now time.Time) bool {
+	if o.CertAuthority {
+		return false
+	}
+	if !o.ExpiryTime.IsZero() && !now.Before(o.ExpiryTime) {
+		return false
+	}
+	if o.From != nil && !addrMatchPatterns(remoteAddr, o.From) {
+		return false
+	}
+	return true
+}
+
+func (o *KeyOptions) PermitOpenTarget(target string) bool {
+	if o.NoPortForwarding {
+		return false
+	}
+	return o.PermitOpen == nil || hostPortMatchAny(target, o.PermitOpen)
+}
+
+func (o *KeyOptions) PermitListenTarget(target string) bool {
+	if o.NoPortForwarding {
+		return false
+	}
+	return o.PermitListen == nil || hostPortMatchAny(target, o.PermitListen)
+}
+
+func hostPortMatchAny(target string, permits []string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	for _, permit := range permits {
+		permitHost, permitPort, err := net.SplitHostPort(permit)
+		if err != nil {
+			continue
+		}
+		if (permitHost == "*" || strings.EqualFold(permitHost, host)) &&
+			(permitPort == "*" || permitPort == port) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrMatchPatterns matches like sshd's from=: wildcards, CIDR and negation with '!'.
+func addrMatchPatterns(addr net.Addr, patterns []string) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var ok bool
+		if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+			ok = ipnet.Contains(ip)
+		} else {
+			ok, _ = path.Match(pattern, ip.String())
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// context
+
+type keyOptionsContextKey struct {
+	Scope       string
+	Fingerprint string
+}
+
+type keyOptionsScopeContextKey struct{}
+
+type keyOptionsScopeContext struct {
+	context.Context
+	scope string
+}
+
+func (c *keyOptionsScopeContext) Value(key any) any {
+	if key == (keyOptionsScopeContextKey{}) {
+		return c.scope
+	}
+	return c.Context.Value(key)
+}
+
+func (c *keyOptionsScopeContext) SetValue(key, value any) {
+	if setter, ok := c.Context.(interface{ SetValue(key, value any) }); ok {
+		setter.SetValue(key, value)
+	}
+}
+
+// WithKeyOptionsScope keeps the key options stored and read through ctx apart from other scopes,
+// so a key listed with different options in the files of several handlers gets the right ones in each.
+func WithKeyOptionsScope(ctx context.Context, scope string) context.Context {
+	return &keyOptionsScopeContext{Context: ctx, scope: scope}
+}
+
+func keyOptionsKey(ctx context.Context, key gossh.PublicKey) keyOptionsContextKey {
+	scope, _ := ctx.Value(keyOptionsScopeContextKey{}).(string)
+	return keyOptionsContextKey{Scope: scope, Fingerprint: gossh.FingerprintSHA256(key)}
+}
+
+func acceptAuthorizedKey(ctx context.Context, req AuthenticateRequest, options []string) bool {
+	opts, err := ParseKeyOptions(options)
+	if err != nil {
+		return false
+	}
+	if !opts.Allowed(req.RemoteAddr, time.Now()) {
+		return false
+	}
+
+	if setter, ok := ctx.(interface{ SetValue(key, value any) }); ok {
+		setter.SetValue(keyOptionsKey(ctx, req.PublicKey), opts)
+	}
+	return true
+}
+
+// KeyOptionsFromContext returns the options of the public key that the connection authenticated with,
+// as stored in the scope of ctx.
+func KeyOptionsFromContext(ctx context.Context) (*KeyOptions, bool) {
+	key, ok := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey)
+	if !ok || key == nil {
+		return nil, false
+	}
+	opts, ok := ctx.Value(keyOptionsKey(ctx, key)).(*KeyOptions)
+	return opts, ok
+}
+
+// PermitOpenAuthorizer applies permitopen and no-port-forwarding to proxy targets.
+// Connections without key options are allowed.
+func PermitOpenAuthorizer() Authorizer {
+	return AuthorizeFunc(func(ctx context.Context, req AuthorizeRequest) bool {
+		opts, ok := KeyOptionsFromContext(ctx)
+		return !ok || opts.PermitOpenTarget(req.Target)
+	})
+}
+
+// PermitListenAuthorizer applies permitlisten and no-port-forwarding to reverse proxy targets.
+// Connections without key options are allowed.
+func PermitListenAuthorizer() Authorizer {
+	return AuthorizeFunc(func(ctx context.Context, req AuthorizeRequest) bool {
+		opts, ok := KeyOptionsFromContext(ctx)
+		return !ok || opts.PermitListenTarget(req.Target)
+	})
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/pigeonligh/srp/pkg/fswatch"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// dirCache keeps the parsed per-user files of a directory, and reloads them when the directory changes.
+type dirCache[T any] struct {
+	dir   string
+	parse func(filename string, data []byte) (T, []error)
+
+	entries map[string]T
+	errs    []error
+	mu      sync.RWMutex
+
+	reloads atomic.Uint64
+}
+
+func newDirCache[T any](ctx context.Context, dir string, interval time.Duration, parse func(string, []byte) (T, []error)) *dirCache[T] {
+	c := &dirCache[T]{
+		dir:     dir,
+		parse:   parse,
+		entries: make(map[string]T),
+	}
+	fswatch.Watch(ctx, dir, interval, c.load)
+	c.load()
+	return c
+}
+
+func (c *dirCache[T]) load() {
+	entries := make(map[string]T)
+	errs := make([]error, 0)
+
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		filename := filepath.Join(c.dir, f.Name())
+		stat, err := os.Stat(filename)
+		if err != nil || stat.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		value, fileErrs := c.parse(filename, data)
+		entries[f.Name()] = value
+		errs = append(errs, fileErrs...)
+	}
+
+	for _, err := range errs {
+		logrus.Warnf("Load %v: %v", c.dir, err)
+	}
+
+	c.mu.Lock()
+	c.entries = entries
+	c.errs = errs
+	c.mu.Unlock()
+	c.reloads.Add(1)
+}
+
+func (c *dirCache[T]) get(user string) (T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.entries[user]
+	return value, ok
+}
+
+// Reloads counts how many times the directory was loaded, including the initial load.
+func (c *dirCache[T]) Reloads() uint64 {
+	return c.reloads.Load()
+}
+
+// Errors returns the problems found by the last load, with file:line when known.
+func (c *dirCache[T]) Errors() []error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.errs
+}
+
+// CachedPublicKeysDir is a PublicKeysDir that parses files only when they change.
+type CachedPublicKeysDir struct {
+	*dirCache[[]AuthorizedKey]
+}
+
+func NewCachedPublicKeysDir(ctx context.Context, dir string, pollInterval time.Duration) *CachedPublicKeysDir {
+	return &CachedPublicKeysDir{newDirCache(ctx, dir, pollInterval, parseAuthorizedKeys)}
+}
+
+func (d *CachedPublicKeysDir) AuthorizedKeys(ctx context.Context, user string) []AuthorizedKey {
+	keys, _ := d.get(user)
+	return keys
+}
+
+func (d *CachedPublicKeysDir) PublicKeys(ctx context.Context, user string) []gossh.PublicKey {
+	keys, _ := d.get(user)
+	ret := make([]gossh.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, key.PublicKey)
+	}
+	return ret
+}
+
+// CachedUserGlobsDir is a UserGlobsDir that parses files only when they change.
+type CachedUserGlobsDir struct {
+	*dirCache[[]Rule]
+}
+
+func NewCachedUserGlobsDir(ctx context.Context, dir string, pollInterval time.Duration) *CachedUserGlobsDir {
+	return &CachedUserGlobsDir{newDirCache(ctx, dir, pollInterval, parseRules)}
+}
+
+func (d *CachedUserGlobsDir) Rules(ctx context.Context, user string) []Rule {
+	rules, _ := d.get(user)
+	return rules
+}
+
+func (d *CachedUserGlobsDir) Globs(ctx context.Context, user string) []glob.Glob {
+	rules, _ := d.get(user)
+	return allowGlobs(rules)
+}
+
+var (
+	_ UserAuthorizedKeys = &CachedPublicKeysDir{}
+	_ UserPublicKeys     = &CachedPublicKeysDir{}
+	_ UserRules          = &CachedUserGlobsDir{}
+	_ UserGlobs          = &CachedUserGlobsDir{}
+)
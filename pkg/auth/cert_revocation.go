@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type CertRevocation interface {
+	Revoked(ctx context.Context, cert *gossh.Certificate) bool
+}
+
+type CertRevocationFunc func(ctx context.Context, cert *gossh.Certificate) bool
+
+func (f CertRevocationFunc) Revoked(ctx context.Context, cert *gossh.Certificate) bool {
+	return f(ctx, cert)
+}
+
+type RevokedSerials []uint64
+
+func (s RevokedSerials) Revoked(ctx context.Context, cert *gossh.Certificate) bool {
+	for _, serial := range s {
+		if serial == cert.Serial {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokedSerialsFile lists one serial per line, or an inclusive range like "100-200".
+// An unreadable or malformed file revokes every certificate.
+type RevokedSerialsFile string
+
+func (f RevokedSerialsFile) Revoked(ctx context.Context, cert *gossh.Certificate) bool {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return true
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		minString, maxString, isRange := strings.Cut(line, "-")
+		if !isRange {
+			maxString = minString
+		}
+		min, err := strconv.ParseUint(strings.TrimSpace(minString), 0, 64)
+		if err != nil {
+			return true
+		}
+		max, err := strconv.ParseUint(strings.TrimSpace(maxString), 0, 64)
+		if err != nil {
+			return true
+		}
+		if min <= cert.Serial && cert.Serial <= max {
+			return true
+		}
+	}
+	return false
+}
+
+// KRLFile reads an OpenSSH key revocation list, as generated by `ssh-keygen -k`.
+// An unreadable or malformed file revokes every certificate.
+type KRLFile string
+
+func (f KRLFile) Revoked(ctx context.Context, cert *gossh.Certificate) bool {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return true
+	}
+	krl, err := ParseKRL(data)
+	if err != nil {
+		return true
+	}
+	return krl.Revoked(ctx, cert)
+}
+
+// KRL format: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.krl
+
+const (
+	krlMagic         = 0x5353484b524c0a00
+	krlFormatVersion = 1
+
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlSectionCertSerialList   = 0x20
+	krlSectionCertSerialRange  = 0x21
+	krlSectionCertSerialBitmap = 0x22
+	krlSectionCertKeyID        = 0x23
+)
+
+type krlSerialRange struct {
+	min, max uint64
+}
+
+type krlCertificates struct {
+	ca      gossh.PublicKey // nil matches any CA
+	serials []krlSerialRange
+	bitmaps []krlSerialBitmap
+	keyIDs  map[string]bool
+}
+
+type krlSerialBitmap struct {
+	offset uint64
+	bitmap *big.Int
+}
+
+type KRL struct {
+	Version uint64
+	Comment string
+
+	certificates []krlCertificates
+	keys         map[string]bool
+	sha1         map[string]bool
+	sha256       map[string]bool
+}
+
+type krlReader struct {
+	data []byte
+}
+
+var errKRLTruncated = errors.New("krl: truncated data")
+
+func (r *krlReader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *krlReader) byte() (byte, error) {
+	if len(r.data) < 1 {
+		return 0, errKRLTruncated
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
+
+func (r *krlReader) uint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errKRLTruncated
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *krlReader) uint64() (uint64, error) {
+	if len(r.data) < 8 {
+		return 0, errKRLTruncated
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v, nil
+}
+
+func (r *krlReader) string() ([]byte, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(r.data)) < n {
+		return nil, errKRLTruncated
+	}
+	s := r.data[:n]
+	r.data = r.data[n:]
+	return s, nil
+}
+
+func ParseKRL(data []byte) (*KRL, error) {
+	r := &krlReader{data: data}
+	magic, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if magic != krlMagic {
+		return nil, fmt.Errorf("krl: bad magic")
+	}
+	format, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if format != krlFormatVersion {
+		return nil, fmt.Errorf("krl: unsupported format version %v", format)
+	}
+
+	krl := &KRL{
+		keys:   make(map[string]bool),
+		sha1:   make(map[string]bool),
+		sha256: make(map[string]bool),
+	}
+	if krl.Version, err = r.uint64(); err != nil {
+		return nil, err
+	}
+	if _, err = r.uint64(); err != nil { // generated_date
+		return nil, err
+	}
+	if _, err = r.uint64(); err != nil { // flags
+		return nil, err
+	}
+	if _, err = r.string(); err != nil { // reserved
+		return nil, err
+	}
+	comment, err := r.string()
+	if err != nil {
+		return nil, err
+	}
+	krl.Comment = string(comment)
+
+	for !r.empty() {
+		sectionType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if sectionType == krlSectionSignature {
+			// Signatures are only appended at the end and are not verified here.
+			break
+		}
+		sectionData, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		switch sectionType {
+		case krlSectionCertificates:
+			certs, err := parseKRLCertificates(sectionData)
+			if err != nil {
+				return nil, err
+			}
+			krl.certificates = append(krl.certificates, *certs)
+
+		case krlSectionExplicitKey, krlSectionFingerprintSHA1, krlSectionFingerprintSHA256:
+			set := map[byte]map[string]bool{
+				krlSectionExplicitKey:       krl.keys,
+				krlSectionFingerprintSHA1:   krl.sha1,
+				krlSectionFingerprintSHA256: krl.sha256,
+			}[sectionType]
+			sr := &krlReader{data: sectionData}
+			for !sr.empty() {
+				item, err := sr.string()
+				if err != nil {
+					return nil, err
+				}
+				set[string(item)] = true
+			}
+
+		default:
+			return nil, fmt.Errorf("krl: unsupported section type %v", sectionType)
+		}
+	}
+	return krl, nil
+}
+
+func parseKRLCertificates(data []byte) (*krlCertificates, error) {
+	r := &krlReader{data: data}
+	caBlob, err := r.string()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.string(); err != nil { // reserved
+		return nil, err
+	}
+
+	certs := &krlCertificates{keyIDs: make(map[string]bool)}
+	if len(caBlob) > 0 {
+		certs.ca, err = gossh.ParsePublicKey(caBlob)
+		if err != nil {
+			return nil, fmt.Errorf("krl: parse ca key: %w", err)
+		}
+	}
+
+	for !r.empty() {
+		sectionType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		sectionData, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		sr := &krlReader{data: sectionData}
+		switch sectionType {
+		case krlSectionCertSerialList:
+			for !sr.empty() {
+				serial, err := sr.uint64()
+				if err != nil {
+					return nil, err
+				}
+				certs.serials = append(certs.serials, krlSerialRange{serial, serial})
+			}
+
+		case krlSectionCertSerialRange:
+			for !sr.empty() {
+				min, err := sr.uint64()
+				if err != nil {
+					return nil, err
+				}
+				max, err := sr.uint64()
+				if err != nil {
+					return nil, err
+				}
+				certs.serials = append(certs.serials, krlSerialRange{min, max})
+			}
+
+		case krlSectionCertSerialBitmap:
+			for !sr.empty() {
+				offset, err := sr.uint64()
+				if err != nil {
+					return nil, err
+				}
+				bitmap, err := sr.string()
+				if err != nil {
+					return nil, err
+				}
+				certs.bitmaps = append(certs.bitmaps, krlSerialBitmap{
+					offset: offset,
+					bitmap: new(big.Int).SetBytes(bitmap),
+				})
+			}
+
+		case krlSectionCertKeyID:
+			for !sr.empty() {
+				keyID, err := sr.string()
+				if err != nil {
+					return nil, err
+				}
+				certs.keyIDs[string(keyID)] = true
+			}
+
+		default:
+			return nil, fmt.Errorf("krl: unsupported certificate section type %v", sectionType)
+		}
+	}
+	return certs, nil
+}
+
+func (krl *KRL) keyRevoked(key gossh.PublicKey) bool {
+	blob := key.Marshal()
+	sha1Sum := sha1.Sum(blob)
+	sha256Sum := sha256.Sum256(blob)
+	return krl.keys[string(blob)] || krl.sha1[string(sha1Sum[:])] || krl.sha256[string(sha256Sum[:])]
+}
+
+func (krl *KRL) Revoked(ctx context.Context, cert *gossh.Certificate) bool {
+	if krl.keyRevoked(cert.Key) || krl.keyRevoked(cert.SignatureKey) {
+		return true
+	}
+
+	caBlob := cert.SignatureKey.Marshal()
+	for _, certs := range krl.certificates {
+		if certs.ca != nil && !bytes.Equal(certs.ca.Marshal(), caBlob) {
+			continue
+		}
+		if certs.keyIDs[cert.KeyId] {
+			return true
+		}
+		// Serial 0 cannot be revoked by serial.
+		if cert.Serial == 0 {
+			continue
+		}
+		for _, r := range certs.serials {
+			if r.min <= cert.Serial && cert.Serial <= r.max {
+				return true
+			}
+		}
+		for _, b := range certs.bitmaps {
+			if cert.Serial >= b.offset && cert.Serial-b.offset < uint64(b.bitmap.BitLen()) &&
+				b.bitmap.Bit(int(cert.Serial-b.offset)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
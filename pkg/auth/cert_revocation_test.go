@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func readTestKey(t *testing.T, name string) gossh.PublicKey {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "krl", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _, _, err := gossh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatalf("parse %v: %v", name, err)
+	}
+	return key
+}
+
+func readTestKRL(t *testing.T, name string) *KRL {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "krl", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	krl, err := ParseKRL(data)
+	if err != nil {
+		t.Fatalf("parse %v: %v", name, err)
+	}
+	return krl
+}
+
+// Revoked does not verify signatures, so the certificates need no signing.
+func testCert(t *testing.T, ca gossh.PublicKey, serial uint64, keyID string) *gossh.Certificate {
+	return &gossh.Certificate{
+		Key:          testPublicKey(t),
+		Serial:       serial,
+		CertType:     gossh.UserCert,
+		KeyId:        keyID,
+		SignatureKey: ca,
+	}
+}
+
+func TestKRLCertificates(t *testing.T) {
+	krl := readTestKRL(t, "certs.krl")
+	if krl.Version != 7 {
+		t.Errorf("Version = %v, want 7", krl.Version)
+	}
+	ca1 := readTestKey(t, "ca1.pub")
+	ca2 := readTestKey(t, "ca2.pub")
+
+	tests := []struct {
+		name   string
+		ca     gossh.PublicKey
+		serial uint64
+		keyID  string
+		want   bool
+	}{
+		{"listed serial", ca1, 5, "", true},
+		{"listed large serial", ca1, 1000000, "", true},
+		{"unlisted serial", ca1, 6, "", false},
+		{"range start", ca1, 100, "", true},
+		{"range middle", ca1, 150, "", true},
+		{"range end", ca1, 200, "", true},
+		{"before range", ca1, 99, "", false},
+		{"after range", ca1, 201, "", false},
+		{"bitmap first", ca1, 300, "", true},
+		{"bitmap middle", ca1, 305, "", true},
+		{"bitmap last", ca1, 310, "", true},
+		{"bitmap gap", ca1, 301, "", false},
+		{"after bitmap", ca1, 311, "", false},
+		{"key id", ca1, 42, "revoked-id", true},
+		{"other key id", ca1, 42, "other-id", false},
+		{"serial of another CA", ca2, 5, "", false},
+		{"range of another CA", ca2, 150, "", false},
+		{"key id of another CA", ca2, 42, "revoked-id", false},
+		{"serial 0", ca1, 0, "", false},
+		{"serial 0 with key id", ca1, 0, "revoked-id", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := krl.Revoked(context.Background(), testCert(t, tt.ca, tt.serial, tt.keyID)); got != tt.want {
+				t.Errorf("Revoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKRLKeys(t *testing.T) {
+	krl := readTestKRL(t, "keys.krl")
+	ca1 := readTestKey(t, "ca1.pub")
+	ca2 := readTestKey(t, "ca2.pub")
+	revoked := readTestKey(t, "revoked.pub")
+
+	cert := testCert(t, ca1, 1, "")
+	if krl.Revoked(context.Background(), cert) {
+		t.Error("certificate with an unrevoked key is revoked")
+	}
+	cert.Key = revoked
+	if !krl.Revoked(context.Background(), cert) {
+		t.Error("certificate for a key revoked by SHA-256 is not revoked")
+	}
+	if !krl.Revoked(context.Background(), testCert(t, ca2, 1, "")) {
+		t.Error("certificate signed by an explicitly revoked CA is not revoked")
+	}
+}
+
+func TestKRLTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "krl", "certs.krl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is 44 bytes with an empty comment; a cut anywhere in it or inside the
+	// single certificate section is malformed.
+	for n := 0; n < len(data); n++ {
+		if n == 44 {
+			continue // a KRL without sections is valid
+		}
+		if _, err := ParseKRL(data[:n]); err == nil {
+			t.Errorf("ParseKRL accepted the first %v of %v bytes", n, len(data))
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "truncated.krl")
+	if err := os.WriteFile(filename, data[:len(data)-1], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !KRLFile(filename).Revoked(context.Background(), testCert(t, readTestKey(t, "ca2.pub"), 1, "")) {
+		t.Error("a malformed KRL file does not revoke every certificate")
+	}
+	if !KRLFile(filepath.Join(t.TempDir(), "missing.krl")).Revoked(context.Background(), testCert(t, readTestKey(t, "ca2.pub"), 1, "")) {
+		t.Error("a missing KRL file does not revoke every certificate")
+	}
+}
+
+// ssh-keygen refuses to revoke serial 0 or to leave out the CA, so these KRLs are built by hand.
+
+func krlString(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+func krlWithCertificates(ca gossh.PublicKey, sections ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint64(nil, krlMagic)
+	b = binary.BigEndian.AppendUint32(b, krlFormatVersion)
+	b = binary.BigEndian.AppendUint64(b, 1) // version
+	b = binary.BigEndian.AppendUint64(b, 0) // generated_date
+	b = binary.BigEndian.AppendUint64(b, 0) // flags
+	b = append(b, krlString(nil)...)        // reserved
+	b = append(b, krlString(nil)...)        // comment
+
+	var caBlob []byte
+	if ca != nil {
+		caBlob = ca.Marshal()
+	}
+	certs := append(krlString(caBlob), krlString(nil)...)
+	for _, s := range sections {
+		certs = append(certs, s...)
+	}
+	return append(append(b, krlSectionCertificates), krlString(certs)...)
+}
+
+func TestKRLSerialZero(t *testing.T) {
+	ca := readTestKey(t, "ca1.pub")
+	list := append([]byte{krlSectionCertSerialList}, krlString(binary.BigEndian.AppendUint64(nil, 0))...)
+	rangeData := binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, 0), 10)
+	ranges := append([]byte{krlSectionCertSerialRange}, krlString(rangeData)...)
+	bitmap := append([]byte{krlSectionCertSerialBitmap}, krlString(append(binary.BigEndian.AppendUint64(nil, 0), krlString([]byte{0x03})...))...)
+
+	krl, err := ParseKRL(krlWithCertificates(ca, list, ranges, bitmap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if krl.Revoked(context.Background(), testCert(t, ca, 0, "")) {
+		t.Error("serial 0 is revoked by serial")
+	}
+	for _, serial := range []uint64{1, 10} {
+		if !krl.Revoked(context.Background(), testCert(t, ca, serial, "")) {
+			t.Errorf("serial %v is not revoked", serial)
+		}
+	}
+}
+
+func TestKRLAnyCA(t *testing.T) {
+	list := append([]byte{krlSectionCertSerialList}, krlString(binary.BigEndian.AppendUint64(nil, 7))...)
+	krl, err := ParseKRL(krlWithCertificates(nil, list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ca1.pub", "ca2.pub"} {
+		if !krl.Revoked(context.Background(), testCert(t, readTestKey(t, name), 7, "")) {
+			t.Errorf("serial 7 of %v is not revoked by a KRL without CA", name)
+		}
+	}
+}
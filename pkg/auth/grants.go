@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Grant allows a user to reach a target for a limited time, and optionally a limited number of times.
+type Grant struct {
+	ID        string // identifies the grant for use counting, derived from the grant when empty
+	User      string
+	Rule      Rule
+	NotBefore time.Time // zero means no lower bound
+	NotAfter  time.Time // zero means no upper bound
+	MaxUses   int       // 0 means unlimited
+}
+
+func (g Grant) Active(now time.Time) bool {
+	if !g.NotBefore.IsZero() && now.Before(g.NotBefore) {
+		return false
+	}
+	if !g.NotAfter.IsZero() && !now.Before(g.NotAfter) {
+		return false
+	}
+	return true
+}
+
+func (g Grant) key() string {
+	if g.ID != "" {
+		return g.ID
+	}
+	return fmt.Sprintf("%v %v %v %v", g.User, g.Rule.Pattern, g.NotBefore.Unix(), g.NotAfter.Unix())
+}
+
+// ParseGrant parses "user [action,...] pattern [not_before=TIME] [not_after=TIME] [max_uses=N] [id=ID]".
+// TIME is RFC 3339 or a date like 2006-01-02 in UTC.
+func ParseGrant(line string) (Grant, error) {
+	var g Grant
+	fields := make([]string, 0)
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			fields = append(fields, field)
+			continue
+		}
+
+		var err error
+		switch key {
+		case "not_before":
+			g.NotBefore, err = parseGrantTime(value)
+		case "not_after":
+			g.NotAfter, err = parseGrantTime(value)
+		case "max_uses":
+			g.MaxUses, err = strconv.Atoi(value)
+			if err == nil && g.MaxUses < 0 {
+				err = fmt.Errorf("negative max_uses")
+			}
+		case "id":
+			g.ID = value
+		default:
+			err = fmt.Errorf("unknown field %q", key)
+		}
+		if err != nil {
+			return Grant{}, err
+		}
+	}
+	if len(fields) < 2 {
+		return Grant{}, fmt.Errorf("invalid grant %q", line)
+	}
+
+	rule, err := ParseRule(strings.Join(fields[1:], " "))
+	if err != nil {
+		return Grant{}, err
+	}
+	if rule.Effect != EffectAllow {
+		return Grant{}, fmt.Errorf("grants cannot deny")
+	}
+	g.User = fields[0]
+	g.Rule = rule
+	return g, nil
+}
+
+func parseGrantTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+type UserGrants interface {
+	Grants(ctx context.Context, user string) []Grant
+}
+
+type UserGrantsFunc func(ctx context.Context, user string) []Grant
+
+func (f UserGrantsFunc) Grants(ctx context.Context, user string) []Grant {
+	return f(ctx, user)
+}
+
+type UserGrantsMap map[string][]Grant
+
+func (m UserGrantsMap) Grants(ctx context.Context, user string) []Grant {
+	return m[user]
+}
+
+// GrantsFile reads one grant per line, see ParseGrant. Malformed lines are skipped.
+type GrantsFile string
+
+func (f GrantsFile) Grants(ctx context.Context, user string) []Grant {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make([]Grant, 0)
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		g, err := ParseGrant(line)
+		if err != nil || g.User != user {
+			continue
+		}
+		if g.ID == "" {
+			g.ID = line
+		}
+		ret = append(ret, g)
+	}
+	return ret
+}
+
+type grantsAuthorizer struct {
+	grants UserGrants
+
+	uses map[string]int
+	mu   sync.Mutex
+}
+
+// GrantsAuthorizer allows requests covered by an active grant.
+// The grant's not_after limits the access, see LimitExpiry.
+// Uses are counted in memory, so they restart with the process. With a context from WithExpiry,
+// only requests that Decide reports as allowed use a grant up.
+func GrantsAuthorizer(grants UserGrants) Authorizer {
+	return &grantsAuthorizer{
+		grants: grants,
+		uses:   make(map[string]int),
+	}
+}
+
+func (a *grantsAuthorizer) Authorize(ctx context.Context, req AuthorizeRequest) bool {
+	now := time.Now()
+	for _, g := range a.grants.Grants(ctx, req.User) {
+		if !g.Active(now) || !g.Rule.Match(req) {
+			continue
+		}
+		if g.MaxUses > 0 {
+			key := g.key()
+			a.mu.Lock()
+			used := a.uses[key]
+			if used >= g.MaxUses {
+				a.mu.Unlock()
+				continue
+			}
+			// The use is reserved, and given back when a later authorizer denies the request.
+			a.uses[key] = used + 1
+			a.mu.Unlock()
+			OnDecision(ctx, func(allowed bool) {
+				if !allowed {
+					a.mu.Lock()
+					a.uses[key]--
+					a.mu.Unlock()
+				}
+			})
+		}
+		LimitExpiry(ctx, g.NotAfter)
+		return true
+	}
+	return false
+}
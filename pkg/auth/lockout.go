@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type LockoutKind string
+
+const (
+	LockoutKindIP   LockoutKind = "ip"
+	LockoutKindUser LockoutKind = "user"
+)
+
+type LockoutEvent struct {
+	Kind     LockoutKind
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+// Lockout counts authentication failures per client IP, and per user with BanUsers,
+// delays further attempts exponentially and bans for a while after too many failures.
+type Lockout struct {
+	// MaxFailures within Window triggers a ban. Zero disables bans.
+	MaxFailures int
+	Window      time.Duration
+	BanDuration time.Duration
+
+	// BaseDelay is applied after the first failure and doubled for each further one, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// BanUsers also bans users, whatever address they come from. Anyone can then lock a user out
+	// by failing as that user, so callers should only check it for guessable credentials like passwords.
+	BanUsers bool
+
+	// Allowlist holds IPs or CIDRs that are never delayed or banned.
+	Allowlist []string
+
+	// OnBan is called when a ban starts, e.g. to update metrics.
+	OnBan func(LockoutEvent)
+
+	Clock func() time.Time // default time.Now
+
+	initOnce  sync.Once
+	allowlist []*net.IPNet
+
+	records map[lockoutKey]*lockoutRecord
+	mu      sync.Mutex
+}
+
+type lockoutKey struct {
+	kind LockoutKind
+	key  string
+}
+
+type lockoutRecord struct {
+	failures    int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+const lockoutSweepSize = 4096
+
+func (l *Lockout) init() {
+	l.initOnce.Do(func() {
+		for _, item := range l.Allowlist {
+			item = strings.TrimSpace(item)
+			if !strings.Contains(item, "/") {
+				if ip := net.ParseIP(item); ip != nil {
+					bits := 8 * len(ip.To16())
+					if ip.To4() != nil {
+						ip, bits = ip.To4(), 32
+					}
+					l.allowlist = append(l.allowlist, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				}
+				continue
+			}
+			if _, ipnet, err := net.ParseCIDR(item); err == nil {
+				l.allowlist = append(l.allowlist, ipnet)
+			}
+		}
+		l.records = make(map[lockoutKey]*lockoutRecord)
+	})
+}
+
+func (l *Lockout) now() time.Time {
+	if l.Clock != nil {
+		return l.Clock()
+	}
+	return time.Now()
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func (l *Lockout) allowlisted(ip net.IP) bool {
+	for _, ipnet := range l.allowlist {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Lockout) keys(addr net.Addr, user string) []lockoutKey {
+	ip := addrIP(addr)
+	if ip != nil && l.allowlisted(ip) {
+		return nil
+	}
+	keys := make([]lockoutKey, 0, 2)
+	if l.BanUsers {
+		keys = append(keys, lockoutKey{LockoutKindUser, user})
+	}
+	if ip != nil {
+		keys = append(keys, lockoutKey{LockoutKindIP, ip.String()})
+	}
+	return keys
+}
+
+// Banned reports whether attempts from addr or for user are currently refused.
+func (l *Lockout) Banned(addr net.Addr, user string) bool {
+	l.init()
+	return l.banned(l.keys(addr, user))
+}
+
+// BannedAddr reports whether attempts from addr are currently refused, ignoring user bans.
+func (l *Lockout) BannedAddr(addr net.Addr) bool {
+	l.init()
+	ip := addrIP(addr)
+	if ip == nil || l.allowlisted(ip) {
+		return false
+	}
+	return l.banned([]lockoutKey{{LockoutKindIP, ip.String()}})
+}
+
+func (l *Lockout) banned(keys []lockoutKey) bool {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		if r, ok := l.records[k]; ok && now.Before(r.bannedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Failure records a failed attempt and returns how long the caller should delay its answer.
+func (l *Lockout) Failure(addr net.Addr, user string) time.Duration {
+	l.init()
+	now := l.now()
+	events := make([]LockoutEvent, 0)
+	failures := 0
+
+	l.mu.Lock()
+	l.sweepLocked(now)
+	for _, k := range l.keys(addr, user) {
+		r, ok := l.records[k]
+		if !ok {
+			r = &lockoutRecord{}
+			l.records[k] = r
+		}
+		if l.Window > 0 && now.Sub(r.lastFailure) > l.Window {
+			r.failures = 0
+		}
+		r.failures++
+		r.lastFailure = now
+		failures = max(failures, r.failures)
+
+		if l.MaxFailures > 0 && r.failures >= l.MaxFailures && !now.Before(r.bannedUntil) {
+			r.bannedUntil = now.Add(l.BanDuration)
+			events = append(events, LockoutEvent{
+				Kind:     k.kind,
+				Key:      k.key,
+				Failures: r.failures,
+				Until:    r.bannedUntil,
+			})
+			r.failures = 0
+		}
+	}
+	l.mu.Unlock()
+
+	for _, e := range events {
+		logrus.Warnf("Ban %v %v after %v authentication failures until %v", e.Kind, e.Key, e.Failures, e.Until.Format(time.RFC3339))
+		if l.OnBan != nil {
+			l.OnBan(e)
+		}
+	}
+
+	if l.BaseDelay <= 0 || failures == 0 {
+		return 0
+	}
+	delay := l.BaseDelay
+	for i := 1; i < failures && (l.MaxDelay <= 0 || delay < l.MaxDelay); i++ {
+		delay *= 2
+	}
+	if l.MaxDelay > 0 {
+		delay = min(delay, l.MaxDelay)
+	}
+	return delay
+}
+
+// Success forgets the failures of user. Failures of the address are kept until they expire.
+func (l *Lockout) Success(addr net.Addr, user string) {
+	l.init()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.records[lockoutKey{LockoutKindUser, user}]; ok && !l.now().Before(r.bannedUntil) {
+		delete(l.records, lockoutKey{LockoutKindUser, user})
+	}
+}
+
+func (l *Lockout) sweepLocked(now time.Time) {
+	if len(l.records) < lockoutSweepSize {
+		return
+	}
+	for k, r := range l.records {
+		if now.Before(r.bannedUntil) {
+			continue
+		}
+		if l.Window <= 0 || now.Sub(r.lastFailure) > l.Window {
+			delete(l.records, k)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestLockout(now *time.Time) *Lockout {
+	return &Lockout{
+		MaxFailures: 3,
+		Window:      10 * time.Minute,
+		BanDuration: 15 * time.Minute,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Clock: func() time.Time {
+			return *now
+		},
+	}
+}
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLockout(&now)
+	l.MaxFailures = 0
+	addr := tcpAddr("192.0.2.1")
+
+	for i, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		if got := l.Failure(addr, "alice"); got != want {
+			t.Errorf("failure %v: delay %v, want %v", i+1, got, want)
+		}
+	}
+	if l.Banned(addr, "alice") {
+		t.Error("banned with MaxFailures 0")
+	}
+
+	// Failures older than the window are forgotten.
+	now = now.Add(11 * time.Minute)
+	if got := l.Failure(addr, "alice"); got != 100*time.Millisecond {
+		t.Errorf("delay after the window %v, want the base delay", got)
+	}
+}
+
+func TestLockoutBan(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLockout(&now)
+	var events []LockoutEvent
+	l.OnBan = func(e LockoutEvent) {
+		events = append(events, e)
+	}
+	addr := tcpAddr("192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		l.Failure(addr, "alice")
+	}
+	if l.Banned(addr, "alice") {
+		t.Fatal("banned before MaxFailures")
+	}
+	l.Failure(addr, "alice")
+	if !l.Banned(addr, "alice") || !l.BannedAddr(addr) {
+		t.Fatal("not banned at MaxFailures")
+	}
+	if !l.Banned(addr, "bob") {
+		t.Error("IP ban does not cover other users")
+	}
+	if l.Banned(tcpAddr("192.0.2.2"), "alice") {
+		t.Error("user is banned from other addresses without BanUsers")
+	}
+	if len(events) != 1 || events[0].Kind != LockoutKindIP || events[0].Key != "192.0.2.1" ||
+		!events[0].Until.Equal(now.Add(15*time.Minute)) {
+		t.Errorf("OnBan events %+v", events)
+	}
+
+	// Success does not lift an IP ban.
+	l.Success(addr, "alice")
+	now = now.Add(14 * time.Minute)
+	if !l.Banned(addr, "alice") {
+		t.Error("ban ends early")
+	}
+	now = now.Add(2 * time.Minute)
+	if l.Banned(addr, "alice") {
+		t.Error("ban does not expire")
+	}
+	// The count starts over after a ban.
+	l.Failure(addr, "alice")
+	if l.Banned(addr, "alice") {
+		t.Error("banned again after one failure")
+	}
+}
+
+func TestLockoutBanUsers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLockout(&now)
+	l.BanUsers = true
+
+	// Failures for one user from many addresses.
+	for i := 0; i < 3; i++ {
+		l.Failure(tcpAddr(net.IPv4(192, 0, 2, byte(i+1)).String()), "alice")
+	}
+	other := tcpAddr("198.51.100.1")
+	if !l.Banned(other, "alice") {
+		t.Error("user is not banned from a new address")
+	}
+	if l.Banned(other, "bob") || l.BannedAddr(other) {
+		t.Error("user ban covers other users or addresses")
+	}
+	if l.BannedAddr(tcpAddr("192.0.2.1")) {
+		t.Error("address is banned by a user ban")
+	}
+
+	// Success forgets failures that did not lead to a ban yet.
+	l.Failure(other, "bob")
+	l.Failure(other, "bob")
+	l.Success(other, "bob")
+	l.Failure(tcpAddr("198.51.100.2"), "bob")
+	if l.Banned(tcpAddr("198.51.100.3"), "bob") {
+		t.Error("failures are kept after a success")
+	}
+}
+
+func TestLockoutAllowlist(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newTestLockout(&now)
+	l.BanUsers = true
+	l.Allowlist = []string{"192.0.2.1", " 10.0.0.0/8 ", "2001:db8::/32", "not an address"}
+
+	for _, ip := range []string{"192.0.2.1", "10.1.2.3", "2001:db8::1"} {
+		addr := tcpAddr(ip)
+		for i := 0; i < 5; i++ {
+			if delay := l.Failure(addr, "alice"); delay != 0 {
+				t.Errorf("%v is delayed by %v", ip, delay)
+			}
+		}
+		if l.Banned(addr, "alice") || l.BannedAddr(addr) {
+			t.Errorf("%v is banned", ip)
+		}
+	}
+	if l.Banned(tcpAddr("198.51.100.1"), "alice") {
+		t.Error("failures from allowlisted addresses count for the user")
+	}
+
+	addr := tcpAddr("192.0.2.2")
+	for i := 0; i < 3; i++ {
+		l.Failure(addr, "alice")
+	}
+	if !l.BannedAddr(addr) {
+		t.Error("address next to an allowlisted one is not banned")
+	}
+	// An allowlisted address is let in even when the user is banned.
+	if l.Banned(tcpAddr("192.0.2.1"), "alice") {
+		t.Error("allowlisted address is refused for a banned user")
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordHashAlgorithm string
+
+const (
+	PasswordHashBcrypt   PasswordHashAlgorithm = "bcrypt"
+	PasswordHashArgon2id PasswordHashAlgorithm = "argon2id"
+	PasswordHashSHA256   PasswordHashAlgorithm = "sha256"
+	PasswordHashSHA512   PasswordHashAlgorithm = "sha512"
+)
+
+const (
+	argon2Memory  = 64 * 1024
+	argon2Time    = 3
+	argon2Threads = 4
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+func HashPassword(algorithm PasswordHashAlgorithm, password string) (string, error) {
+	switch algorithm {
+	case PasswordHashBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+
+	case PasswordHashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+
+	case PasswordHashSHA256, PasswordHashSHA512:
+		salt := make([]byte, shaCryptSaltMax)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		for i := range salt {
+			salt[i] = cryptAlphabet[int(salt[i])%len(cryptAlphabet)]
+		}
+		prefix := "$5$"
+		if algorithm == PasswordHashSHA512 {
+			prefix = "$6$"
+		}
+		return shaCrypt(password, prefix+string(salt))
+	}
+	return "", fmt.Errorf("unknown password hash algorithm %q", algorithm)
+}
+
+// VerifyPassword checks password against a bcrypt, argon2 or sha-crypt hash in constant time.
+func VerifyPassword(hashed, password string) bool {
+	switch {
+	case strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"), strings.HasPrefix(hashed, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+
+	case strings.HasPrefix(hashed, "$argon2id$"), strings.HasPrefix(hashed, "$argon2i$"):
+		return verifyArgon2(hashed, password)
+
+	case strings.HasPrefix(hashed, "$5$"), strings.HasPrefix(hashed, "$6$"):
+		got, err := shaCrypt(password, hashed)
+		return err == nil && subtle.ConstantTimeCompare([]byte(got), []byte(hashed)) == 1
+	}
+	return false
+}
+
+func verifyArgon2(hashed, password string) bool {
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$key
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+
+	var got []byte
+	if parts[1] == "argon2id" {
+		got = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	} else {
+		got = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test vectors of https://www.akkadia.org/drepper/SHA-crypt.txt
+var shaCryptVectors = []struct {
+	setting, password, want string
+}{
+	{"$5$saltstring", "Hello world!",
+		"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	{"$5$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{"$5$rounds=5000$toolongsaltstring", "This is just a test",
+		"$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5"},
+	{"$5$rounds=1400$anotherlongsaltstring", "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		"$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1"},
+	{"$5$rounds=77777$short", "we have a short salt string but not a short password",
+		"$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/"},
+	{"$5$rounds=123456$asaltof16chars..", "a short string",
+		"$5$rounds=123456$asaltof16chars..$gP3VQ/6X7UUEW3HkBn2w1/Ptq2jxPyzV/cZKmF/wJvD"},
+	{"$5$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC"},
+
+	{"$6$saltstring", "Hello world!",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"$6$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+	{"$6$rounds=5000$toolongsaltstring", "This is just a test",
+		"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+	{"$6$rounds=1400$anotherlongsaltstring", "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1"},
+	{"$6$rounds=77777$short", "we have a short salt string but not a short password",
+		"$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0"},
+	{"$6$rounds=123456$asaltof16chars..", "a short string",
+		"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1"},
+	{"$6$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+}
+
+func TestShaCryptVectors(t *testing.T) {
+	for _, v := range shaCryptVectors {
+		got, err := shaCrypt(v.password, v.setting)
+		if err != nil {
+			t.Errorf("shaCrypt(%q): %v", v.setting, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("shaCrypt(%q) = %q, want %q", v.setting, got, v.want)
+		}
+	}
+}
+
+func TestVerifyPasswordVectors(t *testing.T) {
+	for _, v := range shaCryptVectors {
+		if strings.Contains(v.setting, "roundstoolow") {
+			continue // the setting differs from the hash
+		}
+		if !VerifyPassword(v.want, v.password) {
+			t.Errorf("VerifyPassword(%q) rejects the right password", v.want)
+		}
+		if VerifyPassword(v.want, v.password+"x") {
+			t.Errorf("VerifyPassword(%q) accepts a wrong password", v.want)
+		}
+	}
+}
+
+func TestShaCryptInvalid(t *testing.T) {
+	for _, setting := range []string{
+		"$1$saltstring",
+		"$5$rounds=abc$saltstring",
+		"$6$rounds=-1$saltstring",
+		"$5$rounds=5000",
+	} {
+		if _, err := shaCrypt("password", setting); err == nil {
+			t.Errorf("shaCrypt accepted %q", setting)
+		}
+	}
+}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm PasswordHashAlgorithm
+		prefix    string
+	}{
+		{PasswordHashBcrypt, "$2a$"},
+		{PasswordHashArgon2id, "$argon2id$v=19$"},
+		{PasswordHashSHA256, "$5$"},
+		{PasswordHashSHA512, "$6$"},
+	} {
+		t.Run(string(tt.algorithm), func(t *testing.T) {
+			hashed, err := HashPassword(tt.algorithm, "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hashed, tt.prefix) {
+				t.Errorf("hash %q does not start with %q", hashed, tt.prefix)
+			}
+			if !VerifyPassword(hashed, "correct horse") {
+				t.Error("VerifyPassword rejects the right password")
+			}
+			if VerifyPassword(hashed, "correct horse ") {
+				t.Error("VerifyPassword accepts a wrong password")
+			}
+
+			again, err := HashPassword(tt.algorithm, "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == hashed {
+				t.Error("hashes of the same password share a salt")
+			}
+		})
+	}
+
+	if _, err := HashPassword("md5", "password"); err == nil {
+		t.Error("HashPassword accepted an unknown algorithm")
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	for _, hashed := range []string{
+		"",
+		"password",
+		"$1$saltstring$hash",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=18$m=65536,t=3,p=4$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=x$c2FsdHNhbHQ$a2V5",
+		"$2a$10$short",
+	} {
+		if VerifyPassword(hashed, "password") {
+			t.Errorf("VerifyPassword(%q) accepts a password", hashed)
+		}
+	}
+}
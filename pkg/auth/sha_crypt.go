@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt: https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+	shaCryptSaltMax       = 16
+
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt hashes password with a "$5$" or "$6$" setting, which may be a full hash.
+func shaCrypt(password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var prefix string
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		newHash, prefix = sha256.New, "$5$"
+	case strings.HasPrefix(setting, "$6$"):
+		newHash, prefix = sha512.New, "$6$"
+	default:
+		return "", fmt.Errorf("not a sha-crypt hash")
+	}
+
+	rest := strings.TrimPrefix(setting, prefix)
+	rounds := shaCryptRoundsDefault
+	customRounds := false
+	if strings.HasPrefix(rest, "rounds=") {
+		roundsString, after, ok := strings.Cut(strings.TrimPrefix(rest, "rounds="), "$")
+		if !ok {
+			return "", fmt.Errorf("invalid sha-crypt rounds")
+		}
+		n, err := strconv.ParseUint(roundsString, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid sha-crypt rounds: %w", err)
+		}
+		rounds = min(max(int(n), shaCryptRoundsMin), shaCryptRoundsMax)
+		customRounds = true
+		rest = after
+	}
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > shaCryptSaltMax {
+		salt = salt[:shaCryptSaltMax]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	h := newHash()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h = newHash()
+	h.Write(p)
+	h.Write(s)
+	for i := len(p); i > 0; i -= len(b) {
+		h.Write(b[:min(i, len(b))])
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	h = newHash()
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+	dp := h.Sum(nil)
+	pSeq := make([]byte, 0, len(p))
+	for i := len(p); i > 0; i -= len(dp) {
+		pSeq = append(pSeq, dp[:min(i, len(dp))]...)
+	}
+
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	sSeq := make([]byte, 0, len(s))
+	for i := len(s); i > 0; i -= len(ds) {
+		sSeq = append(sSeq, ds[:min(i, len(ds))]...)
+	}
+
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(a)
+		}
+		if i%3 != 0 {
+			h.Write(sSeq)
+		}
+		if i%7 != 0 {
+			h.Write(pSeq)
+		}
+		if i&1 != 0 {
+			h.Write(a)
+		} else {
+			h.Write(pSeq)
+		}
+		a = h.Sum(nil)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	if customRounds {
+		fmt.Fprintf(&sb, "rounds=%d$", rounds)
+	}
+	sb.WriteString(salt)
+	sb.WriteString("$")
+
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			sb.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	if prefix == "$5$" {
+		for _, o := range sha256CryptOrder {
+			encode(a[o[0]], a[o[1]], a[o[2]], 4)
+		}
+		encode(0, a[31], a[30], 3)
+	} else {
+		for _, o := range sha512CryptOrder {
+			encode(a[o[0]], a[o[1]], a[o[2]], 4)
+		}
+		encode(0, 0, a[63], 2)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,29 @@
+#!/bin/sh
+# Regenerates the KRL fixtures with ssh-keygen. Only public keys are kept.
+set -e
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+for k in ca1 ca2 revoked; do
+	ssh-keygen -q -t ed25519 -N "" -C "$k" -f "$tmp/$k"
+	cp "$tmp/$k.pub" .
+done
+
+# Serial list (5, 1000000), range (100-200), bitmap (300-310) and key ID sections for ca1.
+cat > "$tmp/certs" <<SPEC
+serial: 5
+serial: 1000000
+serial: 100-200
+serial: 300
+serial: 302
+serial: 305
+serial: 307
+serial: 310
+id: revoked-id
+SPEC
+ssh-keygen -k -f certs.krl -s ca1.pub -z 7 "$tmp/certs"
+
+# revoked.pub by SHA-256, and ca2.pub explicitly.
+printf 'hash: %s\n' "$(ssh-keygen -l -E sha256 -f revoked.pub | cut -d' ' -f2)" > "$tmp/keys"
+ssh-keygen -k -f keys.krl -z 8 "$tmp/keys" ca2.pub
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secrets
+
+type UserTOTPSecrets interface {
+	TOTPSecret(ctx context.Context, user string) (string, bool)
+}
+
+type UserTOTPSecretsFunc func(ctx context.Context, user string) (string, bool)
+
+func (f UserTOTPSecretsFunc) TOTPSecret(ctx context.Context, user string) (string, bool) {
+	return f(ctx, user)
+}
+
+type UserTOTPSecretsMap map[string]string
+
+func (m UserTOTPSecretsMap) TOTPSecret(ctx context.Context, user string) (string, bool) {
+	secret, ok := m[user]
+	return secret, ok
+}
+
+// TOTPSecretsDir reads the base32 secret of each user from the first line of its file.
+type TOTPSecretsDir string
+
+func (d TOTPSecretsDir) TOTPSecret(ctx context.Context, user string) (string, bool) {
+	filename := filepath.Join(string(d), user)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", false
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, true
+	}
+	return "", false
+}
+
+// verifier
+
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1
+)
+
+// TOTP verifies RFC 6238 codes (SHA1, 6 digits, 30 seconds) and rejects reused codes.
+type TOTP struct {
+	Secrets UserTOTPSecrets
+	Clock   func() time.Time // default time.Now
+
+	used   map[string]uint64 // user => last accepted counter
+	usedMu sync.Mutex
+}
+
+func NewTOTP(secrets UserTOTPSecrets) *TOTP {
+	return &TOTP{
+		Secrets: secrets,
+		used:    make(map[string]uint64),
+	}
+}
+
+func (t *TOTP) Enrolled(ctx context.Context, user string) bool {
+	_, ok := t.Secrets.TOTPSecret(ctx, user)
+	return ok
+}
+
+func (t *TOTP) Verify(ctx context.Context, user, code string) bool {
+	secret, ok := t.Secrets.TOTPSecret(ctx, user)
+	if !ok {
+		return false
+	}
+	key, err := DecodeTOTPSecret(secret)
+	if err != nil {
+		return false
+	}
+
+	clock := t.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	code = strings.TrimSpace(code)
+	now := uint64(clock().Unix()) / uint64(totpPeriod/time.Second)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := now + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, counter)), []byte(code)) != 1 {
+			continue
+		}
+
+		t.usedMu.Lock()
+		defer t.usedMu.Unlock()
+		if last, ok := t.used[user]; ok && counter <= last {
+			return false
+		}
+		t.used[user] = counter
+		return true
+	}
+	return false
+}
+
+func DecodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	return key, nil
+}
+
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// "12345678901234567890", the key of RFC 4226 and RFC 6238.
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226 Appendix D
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	key, err := DecodeTOTPSecret(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter)); got != code {
+			t.Errorf("hotp(%v) = %v, want %v", counter, got, code)
+		}
+	}
+}
+
+func newTestTOTP(now *time.Time) *TOTP {
+	v := NewTOTP(UserTOTPSecretsMap{"alice": testTOTPSecret, "bob": testTOTPSecret, "broken": "not base32!"})
+	v.Clock = func() time.Time {
+		return *now
+	}
+	return v
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 Appendix B for SHA1, keeping the last 6 of the 8 digits.
+	for _, tt := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		now := time.Unix(tt.unix, 0)
+		if !newTestTOTP(&now).Verify(context.Background(), "alice", tt.code) {
+			t.Errorf("code %v at %v is rejected", tt.code, tt.unix)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	key, _ := DecodeTOTPSecret(testTOTPSecret)
+	now := time.Unix(1111111111, 0)
+	counter := uint64(now.Unix() / 30)
+
+	for _, tt := range []struct {
+		offset int
+		want   bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	} {
+		v := newTestTOTP(&now)
+		if got := v.Verify(context.Background(), "alice", hotp(key, counter+uint64(tt.offset))); got != tt.want {
+			t.Errorf("code %+d periods away: Verify = %v, want %v", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	key, _ := DecodeTOTPSecret(testTOTPSecret)
+	now := time.Unix(1111111111, 0)
+	counter := uint64(now.Unix() / 30)
+	v := newTestTOTP(&now)
+	ctx := context.Background()
+
+	code := hotp(key, counter)
+	if !v.Verify(ctx, "alice", code) {
+		t.Fatal("code is rejected")
+	}
+	if v.Verify(ctx, "alice", code) {
+		t.Error("code is accepted twice")
+	}
+	if v.Verify(ctx, "alice", hotp(key, counter-1)) {
+		t.Error("code older than an accepted one is accepted")
+	}
+	// Used codes are tracked per user.
+	if !v.Verify(ctx, "bob", code) {
+		t.Error("code used by another user is rejected")
+	}
+
+	// The code of the next period is still accepted, then the one after it once time moves on.
+	if !v.Verify(ctx, "alice", hotp(key, counter+1)) {
+		t.Error("code of the next period is rejected")
+	}
+	now = now.Add(30 * time.Second)
+	if v.Verify(ctx, "alice", hotp(key, counter+1)) {
+		t.Error("code is accepted again in a later period")
+	}
+	if !v.Verify(ctx, "alice", hotp(key, counter+2)) {
+		t.Error("code of the following period is rejected")
+	}
+}
+
+func TestTOTPRejects(t *testing.T) {
+	now := time.Unix(59, 0)
+	v := newTestTOTP(&now)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name, user, code string
+	}{
+		{"unknown user", "carol", "287082"},
+		{"invalid secret", "broken", "287082"},
+		{"wrong code", "alice", "287083"},
+		{"empty code", "alice", ""},
+		{"8 digits", "alice", "94287082"},
+	} {
+		if v.Verify(ctx, tt.user, tt.code) {
+			t.Errorf("%v: code is accepted", tt.name)
+		}
+	}
+	if !v.Enrolled(ctx, "alice") || v.Enrolled(ctx, "carol") {
+		t.Error("Enrolled does not follow the secrets")
+	}
+	// Surrounding spaces are ignored.
+	if !v.Verify(ctx, "alice", " 287082\n") {
+		t.Error("code with spaces is rejected")
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	for _, secret := range []string{
+		testTOTPSecret,
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====",
+	} {
+		key, err := DecodeTOTPSecret(secret)
+		if err != nil || string(key) != "12345678901234567890" {
+			t.Errorf("DecodeTOTPSecret(%q) = %q, %v", secret, key, err)
+		}
+	}
+	if _, err := DecodeTOTPSecret("GEZDGNBV1"); err == nil {
+		t.Error("DecodeTOTPSecret accepted an invalid secret")
+	}
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// authorities
+
+type CertAuthorities interface {
+	Authorities(ctx context.Context) []gossh.PublicKey
+}
+
+type CertAuthoritiesFunc func(ctx context.Context) []gossh.PublicKey
+
+func (f CertAuthoritiesFunc) Authorities(ctx context.Context) []gossh.PublicKey {
+	return f(ctx)
+}
+
+type CertAuthoritiesList []gossh.PublicKey
+
+func (l CertAuthoritiesList) Authorities(ctx context.Context) []gossh.PublicKey {
+	return l
+}
+
+// CertAuthoritiesFile reads CA keys in authorized_keys format, like TrustedUserCAKeys of sshd.
+type CertAuthoritiesFile string
+
+func (f CertAuthoritiesFile) Authorities(ctx context.Context) []gossh.PublicKey {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make([]gossh.PublicKey, 0)
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		publickey, _, _, _, _ := gossh.ParseAuthorizedKey([]byte(line))
+		if publickey != nil {
+			ret = append(ret, publickey)
+		}
+	}
+	return ret
+}
+
+// principals
+
+type UserPrincipals interface {
+	Principals(ctx context.Context, user string) []string
+}
+
+type UserPrincipalsFunc func(ctx context.Context, user string) []string
+
+func (f UserPrincipalsFunc) Principals(ctx context.Context, user string) []string {
+	return f(ctx, user)
+}
+
+type UserPrincipalsMap map[string][]string
+
+func (m UserPrincipalsMap) Principals(ctx context.Context, user string) []string {
+	return m[user]
+}
+
+// UserPrincipalsDir reads one principal per line, like AuthorizedPrincipalsFile of sshd.
+type UserPrincipalsDir string
+
+func (d UserPrincipalsDir) Principals(ctx context.Context, user string) []string {
+	filename := filepath.Join(string(d), user)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make([]string, 0)
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+// authenticator
+
+const (
+	certOptionSourceAddress = "source-address"
+	certOptionForceCommand  = "force-command"
+)
+
+// SRP never runs commands, so force-command is always satisfied.
+var certSupportedCriticalOptions = []string{
+	certOptionSourceAddress,
+	certOptionForceCommand,
+}
+
+// UserCertificateAuthenticator accepts OpenSSH user certificates signed by one of the authorities.
+// The certificate must name the user, or one of the user's principals when principals is set.
+func UserCertificateAuthenticator(authorities CertAuthorities, principals UserPrincipals, revocation CertRevocation) Authenticator {
+	return AuthenticateFunc(func(ctx context.Context, req AuthenticateRequest) bool {
+		cert, ok := req.PublicKey.(*gossh.Certificate)
+		if !ok || cert.CertType != gossh.UserCert {
+			return false
+		}
+		if len(cert.ValidPrincipals) == 0 {
+			return false
+		}
+
+		trusted := false
+		for _, ca := range authorities.Authorities(ctx) {
+			if ssh.KeysEqual(ca, cert.SignatureKey) {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return false
+		}
+
+		checker := &gossh.CertChecker{
+			SupportedCriticalOptions: certSupportedCriticalOptions,
+			IsRevoked: func(cert *gossh.Certificate) bool {
+				return revocation != nil && revocation.Revoked(ctx, cert)
+			},
+			Clock: time.Now,
+		}
+
+		names := []string{req.User}
+		if principals != nil {
+			names = principals.Principals(ctx, req.User)
+		}
+		matched := false
+		for _, name := range names {
+			if checker.CheckCert(name, cert) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+
+		if sourceAddress, ok := cert.CriticalOptions[certOptionSourceAddress]; ok {
+			if !addrInCIDRList(req.RemoteAddr, sourceAddress) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func addrInCIDRList(addr net.Addr, list string) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			if other := net.ParseIP(item); other != nil && other.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(item)
+		if err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestUserCertificateAuthenticator(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := gossh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(modify func(cert *gossh.Certificate)) *gossh.Certificate {
+		cert := &gossh.Certificate{
+			Key:             testPublicKey(t),
+			Serial:          1,
+			CertType:        gossh.UserCert,
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if modify != nil {
+			modify(cert)
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	withSource := func(list string) func(*gossh.Certificate) {
+		return func(cert *gossh.Certificate) {
+			cert.CriticalOptions = map[string]string{certOptionSourceAddress: list}
+		}
+	}
+
+	inside := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 5), Port: 50000}
+	outside := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 50000}
+	authorities := CertAuthoritiesList{ca.PublicKey()}
+
+	tests := []struct {
+		name       string
+		cert       gossh.PublicKey
+		user       string
+		addr       net.Addr
+		principals UserPrincipals
+		revocation CertRevocation
+		want       bool
+	}{
+		{"valid", sign(nil), "alice", inside, nil, nil, true},
+		{"other user", sign(nil), "bob", inside, nil, nil, false},
+		{"no principals", sign(func(c *gossh.Certificate) { c.ValidPrincipals = nil }), "alice", inside, nil, nil, false},
+		{"mapped principal", sign(func(c *gossh.Certificate) { c.ValidPrincipals = []string{"team"} }), "alice", inside, UserPrincipalsMap{"alice": {"team"}}, nil, true},
+		{"user name without mapping", sign(nil), "alice", inside, UserPrincipalsMap{"alice": {"team"}}, nil, false},
+		{"unmapped user", sign(nil), "alice", inside, UserPrincipalsMap{}, nil, false},
+		{"source in CIDR", sign(withSource("10.0.0.0/8, 192.0.2.0/24")), "alice", inside, nil, nil, true},
+		{"source is address", sign(withSource("192.0.2.5")), "alice", inside, nil, nil, true},
+		{"source outside", sign(withSource("192.0.2.0/24")), "alice", outside, nil, nil, false},
+		{"source not IP", sign(withSource("192.0.2.0/24")), "alice", &net.UnixAddr{Name: "/tmp/s", Net: "unix"}, nil, nil, false},
+		{"unsupported critical option", sign(func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"verify-required": ""}
+		}), "alice", inside, nil, nil, false},
+		{"expired", sign(func(c *gossh.Certificate) { c.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix()) }), "alice", inside, nil, nil, false},
+		{"host certificate", sign(func(c *gossh.Certificate) { c.CertType = gossh.HostCert }), "alice", inside, nil, nil, false},
+		{"revoked", sign(nil), "alice", inside, nil, RevokedSerials{1}, false},
+		{"not revoked", sign(nil), "alice", inside, nil, RevokedSerials{2}, true},
+		{"plain key", testPublicKey(t), "alice", inside, nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := UserCertificateAuthenticator(authorities, tt.principals, tt.revocation)
+			got := a.Authenticate(context.Background(), AuthenticateRequest{User: tt.user, PublicKey: tt.cert, RemoteAddr: tt.addr})
+			if got != tt.want {
+				t.Errorf("Authenticate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	untrusted := UserCertificateAuthenticator(CertAuthoritiesList{testPublicKey(t)}, nil, nil)
+	if untrusted.Authenticate(context.Background(), AuthenticateRequest{User: "alice", PublicKey: sign(nil), RemoteAddr: inside}) {
+		t.Error("certificate of an untrusted CA is accepted")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,35 +28,63 @@ func (m UserGlobsMap) Globs(ctx context.Context, user string) []glob.Glob {
 	return m[user]
 }
 
+// UserGlobsDir reads rule files, one "[allow|deny] [action,...] pattern" per line.
 type UserGlobsDir string
 
-func (d UserGlobsDir) Globs(ctx context.Context, user string) []glob.Glob {
+func (d UserGlobsDir) Rules(ctx context.Context, user string) []Rule {
 	filename := filepath.Join(string(d), user)
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil
 	}
+	ret, _ := parseRules(filename, data)
+	return ret
+}
+
+func parseRules(filename string, data []byte) ([]Rule, []error) {
 	sc := bufio.NewScanner(bytes.NewBuffer(data))
-	ret := make([]glob.Glob, 0)
+	ret := make([]Rule, 0)
+	errs := make([]error, 0)
+	lineno := 0
 	for sc.Scan() {
+		lineno++
 		line := sc.Text()
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if !strings.Contains(line, ":") {
-			line = line + ":*"
+
+		rule, err := ParseRule(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v:%v: %w", filename, lineno, err))
+			// Never let a broken rule widen access.
+			rule = Rule{Effect: EffectDeny, Pattern: "*", Glob: glob.MustCompile("*")}
 		}
+		ret = append(ret, rule)
+	}
+	return ret, errs
+}
+
+// Globs returns the patterns of the unscoped allow rules.
+func (d UserGlobsDir) Globs(ctx context.Context, user string) []glob.Glob {
+	return allowGlobs(d.Rules(ctx, user))
+}
 
-		g, _ := glob.Compile(line, '.', ':', '/')
-		if g != nil {
-			ret = append(ret, g)
+func allowGlobs(rules []Rule) []glob.Glob {
+	ret := make([]glob.Glob, 0)
+	for _, rule := range rules {
+		if rule.Effect == EffectAllow && len(rule.Actions) == 0 {
+			ret = append(ret, rule.Glob)
 		}
 	}
 	return ret
 }
 
+// UserGlobsAuthorizer evaluates rules with deny-overrides when c implements UserRules.
 func UserGlobsAuthorizer(c UserGlobs) Authorizer {
+	if rules, ok := c.(UserRules); ok {
+		return UserRulesAuthorizer(rules, RuleModeDenyOverrides)
+	}
 	return AuthorizeFunc(func(ctx context.Context, req AuthorizeRequest) bool {
 		for _, g := range c.Globs(ctx, req.User) {
 			if g.Match(req.Target) {
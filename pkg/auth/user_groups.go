@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// groups
+
+type UserGroups interface {
+	Groups(ctx context.Context, user string) []string
+}
+
+type UserGroupsFunc func(ctx context.Context, user string) []string
+
+func (f UserGroupsFunc) Groups(ctx context.Context, user string) []string {
+	return f(ctx, user)
+}
+
+type UserGroupsMap map[string][]string
+
+func (m UserGroupsMap) Groups(ctx context.Context, user string) []string {
+	return m[user]
+}
+
+// GroupsFile reads "group: member, member" lines.
+// The /etc/group format "group:x:gid:member,member" is accepted as well.
+type GroupsFile string
+
+func (f GroupsFile) Groups(ctx context.Context, user string) []string {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make([]string, 0)
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		var group, members string
+		switch len(fields) {
+		case 2:
+			group, members = fields[0], fields[1]
+		case 4:
+			group, members = fields[0], fields[3]
+		default:
+			continue
+		}
+
+		for _, member := range strings.FieldsFunc(members, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			if member == user {
+				ret = append(ret, strings.TrimSpace(group))
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// roles
+
+type Role struct {
+	Name   string
+	Groups []string
+	Rules  []Rule
+}
+
+type Roles interface {
+	Roles(ctx context.Context) []Role
+}
+
+type RolesFunc func(ctx context.Context) []Role
+
+func (f RolesFunc) Roles(ctx context.Context) []Role {
+	return f(ctx)
+}
+
+type RolesList []Role
+
+func (l RolesList) Roles(ctx context.Context) []Role {
+	return l
+}
+
+// RolesFile reads role sections. Each section starts with "role NAME GROUP[,GROUP...]",
+// followed by rule lines in the UserGlobsDir format.
+type RolesFile string
+
+func (f RolesFile) Roles(ctx context.Context) []Role {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil
+	}
+	roles, err := ParseRoles(data)
+	if err != nil {
+		return nil
+	}
+	return roles
+}
+
+func ParseRoles(data []byte) ([]Role, error) {
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make([]Role, 0)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if strings.ToLower(fields[0]) == "role" {
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %v: expect \"role NAME GROUPS\"", lineno)
+			}
+			ret = append(ret, Role{
+				Name:   fields[1],
+				Groups: strings.Split(fields[2], ","),
+			})
+			continue
+		}
+
+		if len(ret) == 0 {
+			return nil, fmt.Errorf("line %v: rule outside of role", lineno)
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineno, err)
+		}
+		ret[len(ret)-1].Rules = append(ret[len(ret)-1].Rules, rule)
+	}
+	return ret, nil
+}
+
+// UserRoles returns the roles granted to user through its groups, in definition order.
+func UserRoles(ctx context.Context, groups UserGroups, roles Roles, user string) []Role {
+	userGroups := groups.Groups(ctx, user)
+	ret := make([]Role, 0)
+	for _, role := range roles.Roles(ctx) {
+		for _, group := range role.Groups {
+			if slices.Contains(userGroups, group) {
+				ret = append(ret, role)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// RolesRules turns roles into UserRules, so they can be used with UserRulesAuthorizer.
+func RolesRules(groups UserGroups, roles Roles) UserRules {
+	return UserRulesFunc(func(ctx context.Context, user string) []Rule {
+		ret := make([]Rule, 0)
+		for _, role := range UserRoles(ctx, groups, roles, user) {
+			ret = append(ret, role.Rules...)
+		}
+		return ret
+	})
+}
+
+func UserRolesAuthorizer(groups UserGroups, roles Roles, mode RuleMode) Authorizer {
+	return UserRulesAuthorizer(RolesRules(groups, roles), mode)
+}
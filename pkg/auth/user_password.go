@@ -1,7 +1,15 @@
 package auth
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
 type UserPasswordChecker interface {
@@ -18,7 +26,106 @@ type UserPasswordMap map[string]string
 
 func (m UserPasswordMap) Check(ctx context.Context, user, password string) bool {
 	want, ok := m[user]
-	return ok && want == password
+	return ok && subtle.ConstantTimeCompare([]byte(want), []byte(password)) == 1
+}
+
+// HtpasswdFile reads "user:hash" lines with bcrypt, argon2 or sha-crypt hashes.
+type HtpasswdFile string
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// verifyDummyPassword spends about the same time as a real check, so unknown users are not revealed.
+func verifyDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = HashPassword(PasswordHashBcrypt, "")
+	})
+	_ = VerifyPassword(dummyPasswordHash, password)
+}
+
+func (f HtpasswdFile) Check(ctx context.Context, user, password string) bool {
+	hashed, ok := f.lookup(user)
+	if !ok {
+		verifyDummyPassword(password)
+		return false
+	}
+	return VerifyPassword(hashed, password)
+}
+
+func (f HtpasswdFile) lookup(user string) (string, bool) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", false
+	}
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	for sc.Scan() {
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hashed, ok := strings.Cut(line, ":")
+		if ok && name == user {
+			return hashed, true
+		}
+	}
+	return "", false
+}
+
+// SetPassword adds or replaces the hash of user, keeping other lines untouched.
+func (f HtpasswdFile) SetPassword(user, hashed string) error {
+	if user == "" || strings.ContainsAny(user, ":\r\n") {
+		return fmt.Errorf("invalid user name %q", user)
+	}
+	if strings.ContainsAny(hashed, "\r\n") {
+		return fmt.Errorf("invalid password hash")
+	}
+
+	data, err := os.ReadFile(string(f))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	entry := user + ":" + hashed
+	lines := make([]string, 0)
+	replaced := false
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if name, _, ok := strings.Cut(strings.TrimSpace(line), ":"); ok && name == user {
+			if replaced {
+				continue
+			}
+			line = entry
+			replaced = true
+		}
+		lines = append(lines, line)
+	}
+	if !replaced {
+		lines = append(lines, entry)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), ".htpasswd-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
 }
 
 func UserPasswordAuthenticator(c UserPasswordChecker) Authenticator {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -28,33 +29,75 @@ func (m UserPublicKeysMap) PublicKeys(ctx context.Context, user string) []gossh.
 	return m[user]
 }
 
+type AuthorizedKey struct {
+	PublicKey gossh.PublicKey
+	Options   []string
+}
+
+// UserAuthorizedKeys is optionally implemented by UserPublicKeys to provide authorized_keys options.
+type UserAuthorizedKeys interface {
+	AuthorizedKeys(ctx context.Context, user string) []AuthorizedKey
+}
+
 type PublicKeysDir string
 
-func (d PublicKeysDir) PublicKeys(ctx context.Context, user string) []gossh.PublicKey {
+func (d PublicKeysDir) AuthorizedKeys(ctx context.Context, user string) []AuthorizedKey {
 	filename := filepath.Join(string(d), user)
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil
 	}
+	ret, _ := parseAuthorizedKeys(filename, data)
+	return ret
+}
+
+func parseAuthorizedKeys(filename string, data []byte) ([]AuthorizedKey, []error) {
 	sc := bufio.NewScanner(bytes.NewBuffer(data))
-	ret := make([]gossh.PublicKey, 0)
+	ret := make([]AuthorizedKey, 0)
+	errs := make([]error, 0)
+	lineno := 0
 	for sc.Scan() {
+		lineno++
 		line := sc.Text()
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		publickey, _, _, _, _ := gossh.ParseAuthorizedKey([]byte(line))
-		if publickey != nil {
-			ret = append(ret, publickey)
+		publickey, _, options, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v:%v: %w", filename, lineno, err))
+			continue
 		}
+		if _, err := ParseKeyOptions(options); err != nil {
+			errs = append(errs, fmt.Errorf("%v:%v: %w", filename, lineno, err))
+		}
+		ret = append(ret, AuthorizedKey{PublicKey: publickey, Options: options})
+	}
+	return ret, errs
+}
+
+func (d PublicKeysDir) PublicKeys(ctx context.Context, user string) []gossh.PublicKey {
+	keys := d.AuthorizedKeys(ctx, user)
+	ret := make([]gossh.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, key.PublicKey)
 	}
 	return ret
 }
 
+// UserPublicKeysAuthenticator honors authorized_keys options when c implements UserAuthorizedKeys.
 func UserPublicKeysAuthenticator(c UserPublicKeys) Authenticator {
 	return AuthenticateFunc(func(ctx context.Context, req AuthenticateRequest) bool {
+		if ak, ok := c.(UserAuthorizedKeys); ok {
+			for _, key := range ak.AuthorizedKeys(ctx, req.User) {
+				if ssh.KeysEqual(key.PublicKey, req.PublicKey) {
+					return acceptAuthorizedKey(ctx, req, key.Options)
+				}
+			}
+			return false
+		}
+
 		for _, publickey := range c.PublicKeys(ctx, req.User) {
 			if ssh.KeysEqual(publickey, req.PublicKey) {
 				return true
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+type Effect int
+
+const (
+	EffectAllow Effect = iota
+	EffectDeny
+)
+
+type Rule struct {
+	Effect  Effect
+	Actions []Action // empty matches every action
+	Pattern string
+	Glob    glob.Glob
+}
+
+func (r Rule) Match(req AuthorizeRequest) bool {
+	if len(r.Actions) > 0 && !slices.Contains(r.Actions, req.Action) {
+		return false
+	}
+	return r.Glob.Match(req.Target)
+}
+
+// ParseRule parses "[allow|deny] [action,...] pattern".
+// A pattern without port matches every port.
+func ParseRule(line string) (Rule, error) {
+	fields := strings.Fields(line)
+	rule := Rule{Effect: EffectAllow}
+
+	if len(fields) > 1 {
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			fields = fields[1:]
+		case "deny":
+			rule.Effect = EffectDeny
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 1 {
+		for _, action := range strings.Split(fields[0], ",") {
+			switch a := Action(strings.ToLower(action)); a {
+			case ActionPublish, ActionConnect:
+				rule.Actions = append(rule.Actions, a)
+			default:
+				return Rule{}, fmt.Errorf("unknown action %q", action)
+			}
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return Rule{}, fmt.Errorf("invalid rule %q", line)
+	}
+
+	pattern := fields[0]
+	if !strings.Contains(pattern, ":") {
+		pattern = pattern + ":*"
+	}
+	g, err := glob.Compile(pattern, '.', ':', '/')
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	rule.Pattern = pattern
+	rule.Glob = g
+	return rule, nil
+}
+
+type UserRules interface {
+	Rules(ctx context.Context, user string) []Rule
+}
+
+type UserRulesFunc func(ctx context.Context, user string) []Rule
+
+func (f UserRulesFunc) Rules(ctx context.Context, user string) []Rule {
+	return f(ctx, user)
+}
+
+type UserRulesMap map[string][]Rule
+
+func (m UserRulesMap) Rules(ctx context.Context, user string) []Rule {
+	return m[user]
+}
+
+type RuleMode int
+
+const (
+	// RuleModeFirstMatch uses the effect of the first matching rule.
+	RuleModeFirstMatch RuleMode = iota
+	// RuleModeDenyOverrides denies when any rule denies, and allows when any rule allows.
+	RuleModeDenyOverrides
+)
+
+// EvaluateRules returns whether the rules allow req, and whether any rule matched at all.
+func EvaluateRules(rules []Rule, req AuthorizeRequest, mode RuleMode) (allowed bool, matched bool) {
+	for _, r := range rules {
+		if !r.Match(req) {
+			continue
+		}
+		if mode == RuleModeFirstMatch || r.Effect == EffectDeny {
+			return r.Effect == EffectAllow, true
+		}
+		matched = true
+	}
+	return matched, matched
+}
+
+func UserRulesAuthorizer(c UserRules, mode RuleMode) Authorizer {
+	return AuthorizeFunc(func(ctx context.Context, req AuthorizeRequest) bool {
+		allowed, _ := EvaluateRules(c.Rules(ctx, req.User), req, mode)
+		return allowed
+	})
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type WebhookRequest struct {
+	Kind string `json:"kind"` // "authenticate" or "authorize"
+
+	User           string   `json:"user"`
+	Password       string   `json:"password,omitempty"`
+	KeyType        string   `json:"key_type,omitempty"`
+	KeyFingerprint string   `json:"key_fingerprint,omitempty"`
+	CertPrincipals []string `json:"cert_principals,omitempty"`
+
+	Target            string `json:"target,omitempty"`
+	Action            Action `json:"action,omitempty"`
+	RemoteAddress     string `json:"remote_address,omitempty"`
+	SessionID         string `json:"session_id,omitempty"`
+	OriginatorAddress string `json:"originator_address,omitempty"`
+}
+
+type WebhookResponse struct {
+	Allow bool `json:"allow"`
+	TTL   int  `json:"ttl,omitempty"` // seconds to cache the decision, overrides Webhook.CacheTTL
+}
+
+// Webhook asks an external HTTP service for authentication and authorization decisions.
+// Endpoint is an http(s) URL, or "unix:/path/to.sock" to POST to Path over a unix socket.
+type Webhook struct {
+	Endpoint string
+	Path     string
+	Header   http.Header
+	Timeout  time.Duration
+
+	// CacheTTL caches decisions that carry no TTL. Zero disables caching.
+	CacheTTL time.Duration
+	// CacheMaxEntries bounds the cache, whose keys clients choose. Expired entries are dropped first,
+	// then the ones expiring soonest. Default DefaultWebhookCacheMaxEntries.
+	CacheMaxEntries int
+	// FailOpen allows authorization requests when the service cannot give an answer.
+	// Authentication always fails closed, so an unreachable service never lets anyone log in.
+	FailOpen bool
+	// SendPassword forwards passwords to the service; password logins are denied otherwise.
+	SendPassword bool
+
+	initOnce sync.Once
+	client   *http.Client
+	url      string
+
+	cache   map[string]webhookCacheEntry
+	cacheMu sync.Mutex
+}
+
+type webhookCacheEntry struct {
+	allow   bool
+	expires time.Time
+}
+
+const DefaultWebhookCacheMaxEntries = 4096
+
+var (
+	_ Authenticator = &Webhook{}
+	_ Authorizer    = &Webhook{}
+)
+
+func (w *Webhook) init() {
+	w.initOnce.Do(func() {
+		timeout := w.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+
+		if socket, ok := strings.CutPrefix(w.Endpoint, "unix:"); ok {
+			socket = strings.TrimPrefix(socket, "//")
+			w.client = &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						d := net.Dialer{}
+						return d.DialContext(ctx, "unix", socket)
+					},
+				},
+			}
+			w.url = "http://unix/" + strings.TrimPrefix(w.Path, "/")
+		} else {
+			w.client = &http.Client{Timeout: timeout}
+			w.url = strings.TrimSuffix(w.Endpoint, "/")
+			if w.Path != "" {
+				w.url += "/" + strings.TrimPrefix(w.Path, "/")
+			}
+		}
+		w.cache = make(map[string]webhookCacheEntry)
+	})
+}
+
+func (w *Webhook) Authenticate(ctx context.Context, req AuthenticateRequest) bool {
+	wreq := WebhookRequest{
+		Kind: "authenticate",
+		User: req.User,
+	}
+	if req.RemoteAddr != nil {
+		wreq.RemoteAddress = req.RemoteAddr.String()
+	}
+	if req.PublicKey != nil {
+		key := req.PublicKey
+		if cert, ok := key.(*gossh.Certificate); ok {
+			wreq.CertPrincipals = cert.ValidPrincipals
+			key = cert.Key
+		}
+		wreq.KeyType = req.PublicKey.Type()
+		wreq.KeyFingerprint = gossh.FingerprintSHA256(key)
+	} else {
+		if !w.SendPassword {
+			return false
+		}
+		wreq.Password = req.Password
+	}
+	return w.decide(ctx, wreq, req.PublicKey != nil, false)
+}
+
+func (w *Webhook) Authorize(ctx context.Context, req AuthorizeRequest) bool {
+	wreq := WebhookRequest{
+		Kind:              "authorize",
+		User:              req.User,
+		KeyFingerprint:    req.KeyFingerprint,
+		CertPrincipals:    req.CertPrincipals,
+		Target:            req.Target,
+		Action:            req.Action,
+		SessionID:         req.SessionID,
+		OriginatorAddress: req.OriginatorAddress,
+	}
+	if req.RemoteAddr != nil {
+		wreq.RemoteAddress = req.RemoteAddr.String()
+	}
+	return w.decide(ctx, wreq, true, w.FailOpen)
+}
+
+// cacheKey leaves out per-connection fields, so one decision serves every session of a client.
+func webhookCacheKey(req WebhookRequest) string {
+	remoteHost, _, err := net.SplitHostPort(req.RemoteAddress)
+	if err != nil {
+		remoteHost = req.RemoteAddress
+	}
+	return strings.Join([]string{
+		req.Kind, req.User, req.KeyFingerprint, strings.Join(req.CertPrincipals, ","),
+		req.Target, string(req.Action), remoteHost,
+	}, "\x00")
+}
+
+func (w *Webhook) decide(ctx context.Context, req WebhookRequest, cacheable bool, failOpen bool) bool {
+	w.init()
+
+	key := webhookCacheKey(req)
+	if cacheable {
+		w.cacheMu.Lock()
+		entry, ok := w.cache[key]
+		w.cacheMu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.allow
+		}
+	}
+
+	resp, err := w.call(ctx, req)
+	if err != nil {
+		return failOpen
+	}
+
+	ttl := w.CacheTTL
+	if resp.TTL > 0 {
+		ttl = time.Duration(resp.TTL) * time.Second
+	}
+	if cacheable && ttl > 0 {
+		now := time.Now()
+		w.cacheMu.Lock()
+		if _, ok := w.cache[key]; !ok {
+			w.makeRoomLocked(now)
+		}
+		w.cache[key] = webhookCacheEntry{allow: resp.Allow, expires: now.Add(ttl)}
+		w.cacheMu.Unlock()
+	}
+	return resp.Allow
+}
+
+func (w *Webhook) makeRoomLocked(now time.Time) {
+	maxEntries := w.CacheMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultWebhookCacheMaxEntries
+	}
+	if len(w.cache) < maxEntries {
+		return
+	}
+	for k, e := range w.cache {
+		if !now.Before(e.expires) {
+			delete(w.cache, k)
+		}
+	}
+	for len(w.cache) >= maxEntries {
+		var soonest string
+		var soonestExpires time.Time
+		for k, e := range w.cache {
+			if soonestExpires.IsZero() || e.expires.Before(soonestExpires) {
+				soonest, soonestExpires = k, e.expires
+			}
+		}
+		delete(w.cache, soonest)
+	}
+}
+
+func (w *Webhook) call(ctx context.Context, req WebhookRequest) (*WebhookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range w.Header {
+		for _, v := range vs {
+			httpReq.Header.Add(k, v)
+		}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := w.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	// A clear answer from the service is never overridden by FailOpen.
+	if httpResp.StatusCode == http.StatusForbidden || httpResp.StatusCode == http.StatusUnauthorized {
+		return &WebhookResponse{Allow: false}, nil
+	}
+	if httpResp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("webhook status %v", httpResp.Status)
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decode webhook response: %w", err)
+	}
+	return &resp, nil
+}
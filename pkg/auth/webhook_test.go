@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// webhookStandIn answers every decision with handle and records what it was asked.
+type webhookStandIn struct {
+	*httptest.Server
+	calls    atomic.Int32
+	requests chan WebhookRequest
+}
+
+func newWebhookStandIn(t *testing.T, handle func(w http.ResponseWriter, req WebhookRequest)) *webhookStandIn {
+	t.Helper()
+	s := &webhookStandIn{requests: make(chan WebhookRequest, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		select {
+		case s.requests <- req:
+		default:
+		}
+		handle(w, req)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func answer(resp WebhookResponse) func(http.ResponseWriter, WebhookRequest) {
+	return func(w http.ResponseWriter, _ WebhookRequest) {
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func testAuthorizeRequest() AuthorizeRequest {
+	return AuthorizeRequest{
+		User:       "alice",
+		Target:     "example.com:443",
+		Action:     ActionConnect,
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000},
+		SessionID:  "session",
+	}
+}
+
+func testPublicKey(t *testing.T) gossh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWebhookAllowDeny(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(http.ResponseWriter, WebhookRequest)
+		want   bool
+	}{
+		{"allow", answer(WebhookResponse{Allow: true}), true},
+		{"deny", answer(WebhookResponse{Allow: false}), false},
+		{"forbidden", func(w http.ResponseWriter, _ WebhookRequest) {
+			w.WriteHeader(http.StatusForbidden)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newWebhookStandIn(t, tt.handle)
+			// A clear answer is kept even when failing open.
+			w := &Webhook{Endpoint: s.URL, FailOpen: true}
+			if got := w.Authorize(context.Background(), testAuthorizeRequest()); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookRequest(t *testing.T) {
+	s := newWebhookStandIn(t, answer(WebhookResponse{Allow: true}))
+	w := &Webhook{Endpoint: s.URL, Path: "/decide"}
+
+	w.Authorize(context.Background(), testAuthorizeRequest())
+	got := <-s.requests
+	if got.Kind != "authorize" || got.User != "alice" || got.Target != "example.com:443" ||
+		got.Action != ActionConnect || got.RemoteAddress != "192.0.2.1:50000" || got.SessionID != "session" {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	key := testPublicKey(t)
+	if !w.Authenticate(context.Background(), AuthenticateRequest{User: "alice", PublicKey: key}) {
+		t.Error("public key denied")
+	}
+	got = <-s.requests
+	if got.Kind != "authenticate" || got.KeyFingerprint != gossh.FingerprintSHA256(key) || got.Password != "" {
+		t.Errorf("unexpected request %+v", got)
+	}
+}
+
+func TestWebhookPassword(t *testing.T) {
+	s := newWebhookStandIn(t, func(w http.ResponseWriter, req WebhookRequest) {
+		_ = json.NewEncoder(w).Encode(WebhookResponse{Allow: req.Password == "secret"})
+	})
+	req := AuthenticateRequest{User: "alice", Password: "secret"}
+
+	w := &Webhook{Endpoint: s.URL}
+	if w.Authenticate(context.Background(), req) {
+		t.Error("password allowed without SendPassword")
+	}
+	if s.calls.Load() != 0 {
+		t.Error("password sent without SendPassword")
+	}
+
+	w = &Webhook{Endpoint: s.URL, SendPassword: true}
+	if !w.Authenticate(context.Background(), req) {
+		t.Error("password denied")
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := newWebhookStandIn(t, func(w http.ResponseWriter, _ WebhookRequest) {
+		<-release
+	})
+	defer close(release)
+
+	for _, failOpen := range []bool{false, true} {
+		w := &Webhook{Endpoint: s.URL, Timeout: 50 * time.Millisecond, FailOpen: failOpen}
+		start := time.Now()
+		if got := w.Authorize(context.Background(), testAuthorizeRequest()); got != failOpen {
+			t.Errorf("FailOpen %v: got %v", failOpen, got)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("FailOpen %v: took %v", failOpen, elapsed)
+		}
+	}
+}
+
+func TestWebhookAuthenticateFailsClosed(t *testing.T) {
+	release := make(chan struct{})
+	stalled := newWebhookStandIn(t, func(w http.ResponseWriter, _ WebhookRequest) {
+		<-release
+	})
+	defer close(release)
+	failing := newWebhookStandIn(t, func(w http.ResponseWriter, _ WebhookRequest) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"allow": true}`))
+	})
+
+	for name, w := range map[string]*Webhook{
+		"timeout":      {Endpoint: stalled.URL, Timeout: 50 * time.Millisecond, FailOpen: true, SendPassword: true},
+		"server error": {Endpoint: failing.URL, FailOpen: true, SendPassword: true},
+	} {
+		if w.Authenticate(context.Background(), AuthenticateRequest{User: "alice", PublicKey: testPublicKey(t)}) {
+			t.Errorf("%v: public key allowed", name)
+		}
+		if w.Authenticate(context.Background(), AuthenticateRequest{User: "alice", Password: "secret"}) {
+			t.Errorf("%v: password allowed", name)
+		}
+		// Authorization still fails open.
+		if !w.Authorize(context.Background(), testAuthorizeRequest()) {
+			t.Errorf("%v: authorization denied", name)
+		}
+	}
+}
+
+func TestWebhookMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(http.ResponseWriter, WebhookRequest)
+	}{
+		{"invalid json", func(w http.ResponseWriter, _ WebhookRequest) {
+			_, _ = w.Write([]byte(`{"allow": tru`))
+		}},
+		{"wrong type", func(w http.ResponseWriter, _ WebhookRequest) {
+			_, _ = w.Write([]byte(`{"allow": "yes"}`))
+		}},
+		{"server error", func(w http.ResponseWriter, _ WebhookRequest) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"allow": true}`))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newWebhookStandIn(t, tt.handle)
+			for _, failOpen := range []bool{false, true} {
+				w := &Webhook{Endpoint: s.URL, FailOpen: failOpen}
+				if got := w.Authorize(context.Background(), testAuthorizeRequest()); got != failOpen {
+					t.Errorf("FailOpen %v: got %v", failOpen, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookCache(t *testing.T) {
+	s := newWebhookStandIn(t, answer(WebhookResponse{Allow: true}))
+	w := &Webhook{Endpoint: s.URL, CacheTTL: time.Minute}
+
+	req := testAuthorizeRequest()
+	w.Authorize(context.Background(), req)
+	// Another session of the same client is served from the cache.
+	req.SessionID = "other"
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50001}
+	w.Authorize(context.Background(), req)
+	if calls := s.calls.Load(); calls != 1 {
+		t.Errorf("got %v calls, want 1", calls)
+	}
+
+	req.Target = "example.org:443"
+	w.Authorize(context.Background(), req)
+	if calls := s.calls.Load(); calls != 2 {
+		t.Errorf("got %v calls, want 2", calls)
+	}
+}
+
+func TestWebhookCacheBounded(t *testing.T) {
+	s := newWebhookStandIn(t, answer(WebhookResponse{Allow: true}))
+	w := &Webhook{Endpoint: s.URL, CacheTTL: time.Minute, CacheMaxEntries: 8}
+
+	req := testAuthorizeRequest()
+	for i := 0; i < 100; i++ {
+		req.Target = fmt.Sprintf("host%v:443", i)
+		w.Authorize(context.Background(), req)
+	}
+	w.cacheMu.Lock()
+	size := len(w.cache)
+	w.cacheMu.Unlock()
+	if size > 8 {
+		t.Errorf("cache holds %v entries, want at most 8", size)
+	}
+
+	// The newest decision survives the eviction.
+	calls := s.calls.Load()
+	w.Authorize(context.Background(), req)
+	if s.calls.Load() != calls {
+		t.Error("the newest decision was evicted")
+	}
+}
+
+func TestWebhookUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "webhook.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(chan string, 1)
+	s := &httptest.Server{
+		Listener: l,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+			_ = json.NewEncoder(w).Encode(WebhookResponse{Allow: true})
+		})},
+	}
+	s.Start()
+	defer s.Close()
+
+	w := &Webhook{Endpoint: "unix:" + socket, Path: "decide"}
+	if !w.Authorize(context.Background(), testAuthorizeRequest()) {
+		t.Error("denied")
+	}
+	if path := <-paths; path != "/decide" {
+		t.Errorf("got path %q, want /decide", path)
+	}
+}
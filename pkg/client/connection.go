@@ -48,12 +48,13 @@ func (c *sshConnection) Run(ctx context.Context) error {
 		_ = client.Close()
 	}()
 
+	udp := newUDPRemoteForwards(client)
 	for _, proxy := range c.config.Proxies {
 		wg.Add(1)
 		go func(proxy ProxyConfig) {
 			defer wg.Done()
 
-			if err := handleSSHProxy(client, proxy); err != nil {
+			if err := handleSSHProxy(client, udp, proxy); err != nil {
 				select {
 				case errCh <- err:
 				default:
@@ -71,7 +72,17 @@ func (c *sshConnection) Run(ctx context.Context) error {
 	}
 }
 
-func handleSSHProxy(client *gossh.Client, proxy ProxyConfig) error {
+func handleSSHProxy(client *gossh.Client, udp *udpRemoteForwards, proxy ProxyConfig) error {
+	if isUDP(proxy.Network) {
+		switch proxy.Type {
+		case LocalForward:
+			return handleUDPLocalForward(client, proxy)
+		case RemoteForward:
+			return udp.handle(proxy)
+		}
+		return fmt.Errorf("unsupported UDP proxy type")
+	}
+
 	switch proxy.Type {
 	case DynamicForward:
 		return fmt.Errorf("TODO")
@@ -98,7 +109,11 @@ func handleSSHProxy(client *gossh.Client, proxy ProxyConfig) error {
 			func(c net.Conn) (net.Conn, error) {
 				network := proxy.Network
 				address := net.JoinHostPort(proxy.LocalHost, proxy.LocalPort)
-				return net.Dial(network, address)
+				dialer := proxy.Dialer
+				if dialer == nil {
+					dialer = nets.DefaultNetDialer
+				}
+				return dialer.DialContext(context.Background(), network, address)
 			},
 			nil,
 			func(err error) {},
@@ -158,3 +173,7 @@ func handleForward(
 	}()
 	return <-errCh
 }
+
+func isUDP(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
@@ -1,6 +1,9 @@
 package client
 
-import gossh "golang.org/x/crypto/ssh"
+import (
+	"github.com/pigeonligh/srp/pkg/nets"
+	gossh "golang.org/x/crypto/ssh"
+)
 
 type ProxyType int
 
@@ -17,6 +20,8 @@ type ProxyConfig struct {
 	LocalPort  string
 	RemoteHost string
 	RemotePort string
+
+	Dialer nets.NetDialer // dials the local side of a remote forward, default nets.DefaultNetDialer
 }
 
 type ConnConfig struct {
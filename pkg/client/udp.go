@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// udpFlowIdleTimeout closes local forward flows that saw no packets for a while.
+const udpFlowIdleTimeout = 2 * time.Minute
+
+// udpRemoteForwards dispatches the UDP channels of one connection to the remote forwards they belong to.
+type udpRemoteForwards struct {
+	client  *gossh.Client
+	targets map[string]ProxyConfig // "/host/port" => proxy
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+func newUDPRemoteForwards(client *gossh.Client) *udpRemoteForwards {
+	return &udpRemoteForwards{
+		client:  client,
+		targets: make(map[string]ProxyConfig),
+	}
+}
+
+func (u *udpRemoteForwards) handle(proxy ProxyConfig) error {
+	u.once.Do(func() {
+		go u.serve(u.client.HandleChannelOpen(protocol.ForwardedUDPChannelType))
+	})
+
+	target := fmt.Sprintf("/%v/%v", proxy.RemoteHost, proxy.RemotePort)
+	u.mu.Lock()
+	u.targets[target] = proxy
+	u.mu.Unlock()
+
+	ok, _, err := u.client.SendRequest(protocol.UDPForwardRequestType, true, gossh.Marshal(&protocol.UDPForwardRequest{
+		Target: target,
+	}))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("UDP forward %v is rejected", target)
+	}
+	return u.client.Wait()
+}
+
+func (u *udpRemoteForwards) serve(chans <-chan gossh.NewChannel) {
+	for newChan := range chans {
+		var data protocol.ForwardedUDPChannelData
+		if err := gossh.Unmarshal(newChan.ExtraData(), &data); err != nil {
+			_ = newChan.Reject(gossh.ConnectionFailed, "invalid payload")
+			continue
+		}
+		u.mu.Lock()
+		proxy, ok := u.targets[data.Target]
+		u.mu.Unlock()
+		if !ok {
+			_ = newChan.Reject(gossh.Prohibited, "unknown target")
+			continue
+		}
+
+		go func(newChan gossh.NewChannel, proxy ProxyConfig) {
+			dialer := proxy.Dialer
+			if dialer == nil {
+				dialer = nets.DefaultNetDialer
+			}
+			c, err := dialer.DialContext(context.Background(), proxy.Network, net.JoinHostPort(proxy.LocalHost, proxy.LocalPort))
+			if err != nil {
+				_ = newChan.Reject(gossh.ConnectionFailed, err.Error())
+				return
+			}
+			ch, reqs, err := newChan.Accept()
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+			go gossh.DiscardRequests(reqs)
+			_ = nets.HandlePacketConnection(ch, c)
+		}(newChan, proxy)
+	}
+}
+
+type udpFlow struct {
+	ch       gossh.Channel
+	lastSeen time.Time
+}
+
+// handleUDPLocalForward opens a channel for every local peer, and relays its packets.
+func handleUDPLocalForward(client *gossh.Client, proxy ProxyConfig) error {
+	pc, err := net.ListenPacket(proxy.Network, net.JoinHostPort(proxy.LocalHost, proxy.LocalPort))
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(proxy.RemotePort, 10, 32)
+	if err != nil {
+		_ = pc.Close()
+		return fmt.Errorf("invalid remote port %q", proxy.RemotePort)
+	}
+
+	flows := make(map[string]*udpFlow)
+	var mu sync.Mutex
+
+	done := make(chan struct{})
+	defer close(done)
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- client.Wait()
+		_ = pc.Close()
+	}()
+	go func() {
+		t := time.NewTicker(udpFlowIdleTimeout / 4)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			mu.Lock()
+			for _, flow := range flows {
+				if time.Since(flow.lastSeen) > udpFlowIdleTimeout {
+					_ = flow.ch.Close()
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	buf := make([]byte, nets.MaxPacketSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			mu.Lock()
+			for _, flow := range flows {
+				_ = flow.ch.Close()
+			}
+			mu.Unlock()
+			if errors.Is(err, net.ErrClosed) {
+				return <-waitErr
+			}
+			_ = pc.Close()
+			return err
+		}
+
+		mu.Lock()
+		flow, ok := flows[addr.String()]
+		mu.Unlock()
+		if !ok {
+			payload := protocol.DirectPayload{Host: proxy.RemoteHost, Port: uint32(port)}
+			if udpAddr, ok := addr.(*net.UDPAddr); ok {
+				payload.OriginatorAddress = udpAddr.IP.String()
+				payload.OriginatorPort = uint32(udpAddr.Port)
+			}
+			ch, reqs, err := client.OpenChannel(protocol.DirectUDPChannelType, gossh.Marshal(&payload))
+			if err != nil {
+				// The packet is dropped, like UDP would.
+				continue
+			}
+			go gossh.DiscardRequests(reqs)
+
+			flow = &udpFlow{ch: ch}
+			mu.Lock()
+			flows[addr.String()] = flow
+			mu.Unlock()
+
+			go func(addr net.Addr) {
+				b := make([]byte, nets.MaxPacketSize)
+				for {
+					n, err := nets.ReadPacket(ch, b)
+					if err != nil {
+						break
+					}
+					if _, err := pc.WriteTo(b[:n], addr); err != nil {
+						break
+					}
+					// Replies keep the flow alive too, so receive-only flows are not closed as idle.
+					mu.Lock()
+					flow.lastSeen = time.Now()
+					mu.Unlock()
+				}
+				_ = ch.Close()
+				mu.Lock()
+				if flows[addr.String()] == flow {
+					delete(flows, addr.String())
+				}
+				mu.Unlock()
+			}(addr)
+		}
+
+		mu.Lock()
+		flow.lastSeen = time.Now()
+		mu.Unlock()
+		_ = nets.WritePacket(flow.ch, buf[:n])
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/wish"
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/pigeonligh/srp/pkg/proxy"
+	"github.com/pigeonligh/srp/pkg/proxy/providers"
+	"github.com/pigeonligh/srp/pkg/reverseproxy"
+	"github.com/pigeonligh/srp/pkg/server"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func startTestServer(t *testing.T, ctx context.Context) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp, err := reverseproxy.NewWithOptions(reverseproxy.WithUnixDirectory(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := proxy.NewWithOptions(
+		proxy.WithProxyProvider(providers.SocketProvider(rp, 0)),
+		proxy.WithPacketDialer(rp),
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New("test",
+		server.WithReverseProxy(rp),
+		server.WithProxy(p),
+		server.WithListener(l),
+		server.WithSSHOptions(wish.WithHostKeyPEM(pem.EncodeToMemory(block))),
+	)
+	go func() {
+		_ = s.Run(ctx)
+	}()
+	return l.Addr().String()
+}
+
+func freeUDPPort(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return strconv.Itoa(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestUDPForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	address := startTestServer(t, ctx)
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, nets.MaxPacketSize)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+		}
+	}()
+	_, echoPort, _ := net.SplitHostPort(echo.LocalAddr().String())
+
+	localPort := freeUDPPort(t)
+	conn := func(proxy ProxyConfig) Connection {
+		return NewSSHConnection(ConnConfig{
+			Network:     "tcp",
+			Address:     address,
+			User:        "test",
+			AuthMethods: []gossh.AuthMethod{gossh.Password("test")},
+			Proxies:     []ProxyConfig{proxy},
+		}, nets.NetSSHDialer(nil))
+	}
+	go func() {
+		_ = conn(ProxyConfig{
+			Type:       RemoteForward,
+			Network:    "udp",
+			LocalHost:  "127.0.0.1",
+			LocalPort:  echoPort,
+			RemoteHost: "echo",
+			RemotePort: "53",
+		}).Run(ctx)
+	}()
+	go func() {
+		_ = conn(ProxyConfig{
+			Type:       LocalForward,
+			Network:    "udp",
+			LocalHost:  "127.0.0.1",
+			LocalPort:  localPort,
+			RemoteHost: "echo",
+			RemotePort: "53",
+		}).Run(ctx)
+	}()
+
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", localPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Packets are dropped until both forwards are up, so keep sending until one comes back.
+	buf := make([]byte, nets.MaxPacketSize)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("no reply through the UDP forward")
+		}
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := c.Read(buf)
+		if err != nil {
+			continue
+		}
+		if got := string(buf[:n]); got != "echo ping" {
+			t.Fatalf("reply = %q, want %q", got, "echo ping")
+		}
+		break
+	}
+
+	// Later packets of the same flow go through the same channel.
+	for _, msg := range []string{"one", "two"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("reading the reply to %q: %v", msg, err)
+		}
+		if got := string(buf[:n]); got != "echo "+msg {
+			t.Fatalf("reply = %q, want %q", got, "echo "+msg)
+		}
+	}
+}
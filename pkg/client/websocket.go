@@ -0,0 +1,24 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+)
+
+// WebSocketDialer connects to the server through WebSocket at rawURL, e.g. "wss://srp.example.com/ssh",
+// going through the proxy chosen by HTTPS_PROXY and NO_PROXY. Use it with nets.NetSSHDialer.
+func WebSocketDialer(rawURL string, tlsConfig *tls.Config) (nets.NetDialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse websocket url: %w", err)
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	default:
+		return nil, fmt.Errorf("websocket url %v: scheme must be ws or wss", u.Redacted())
+	}
+	return nets.WebSocketDialer(u, tlsConfig, nets.EnvironmentProxyDialer(nil)), nil
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"context"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_CLOSE_WRITE | unix.IN_MODIFY |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+func notify(ctx context.Context, dir string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	// A non-blocking fd is served by the runtime poller, so Close interrupts Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+
+		defer f.Close()
+
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+			if watchRemoved(buf[:n]) {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// watchRemoved reports whether the watched directory went away, after which no more events come.
+func watchRemoved(buf []byte) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		if event.Mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+			return true
+		}
+		buf = buf[min(len(buf), unix.SizeofInotifyEvent+int(event.Len)):]
+	}
+	return false
+}
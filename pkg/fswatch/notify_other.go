@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fswatch
+
+import (
+	"context"
+	"errors"
+)
+
+func notify(ctx context.Context, dir string) (<-chan struct{}, error) {
+	return nil, errors.New("inotify is not supported")
+}
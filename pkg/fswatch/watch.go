@@ -0,0 +1,140 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultInterval = 2 * time.Second
+
+	// Editors and config managers often write a file in several steps.
+	debounce = 100 * time.Millisecond
+)
+
+// Watch calls onChange in the background after something in path changes, until ctx is done.
+// The watch is set up before Watch returns, so no change made afterwards is missed.
+// When path is a file, its directory is watched, so atomic replacements are noticed.
+// It uses inotify where available and polls every interval otherwise,
+// or once the directory is removed or moved away.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	dir := path
+	if stat, err := os.Stat(path); err != nil || !stat.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	events, err := notify(ctx, dir)
+	if err != nil {
+		logrus.Infof("Cannot watch %v with inotify, fall back to polling: %v", dir, err)
+		events = poll(ctx, dir, interval)
+	}
+	go loop(ctx, dir, interval, events, onChange)
+}
+
+func loop(ctx context.Context, dir string, interval time.Duration, events <-chan struct{}, onChange func()) {
+	// Only the inotify watcher closes its channel before ctx is done.
+	fallback := func() <-chan struct{} {
+		if ctx.Err() != nil {
+			return nil
+		}
+		logrus.Infof("Stop watching %v with inotify, fall back to polling", dir)
+		return poll(ctx, dir, interval)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case _, ok := <-events:
+			if !ok {
+				events = fallback()
+			}
+		}
+
+		t := time.NewTimer(debounce)
+	DRAIN:
+		for {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case _, ok := <-events:
+				if !ok {
+					events = fallback()
+				}
+			case <-t.C:
+				break DRAIN
+			}
+		}
+		onChange()
+	}
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+func snapshot(dir string) map[string]fileState {
+	ret := make(map[string]fileState)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ret
+	}
+	for _, e := range entries {
+		// Stat follows symlinks, so swapped symlink targets are noticed too.
+		stat, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		ret[e.Name()] = fileState{size: stat.Size(), modTime: stat.ModTime(), mode: stat.Mode()}
+	}
+	return ret
+}
+
+func poll(ctx context.Context, dir string, interval time.Duration) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	last := snapshot(dir)
+	go func() {
+		defer close(ch)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			current := snapshot(dir)
+			changed := len(current) != len(last)
+			for name, state := range current {
+				if changed {
+					break
+				}
+				if old, ok := last[name]; !ok || old != state {
+					changed = true
+				}
+			}
+			last = current
+
+			if changed {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch
+}
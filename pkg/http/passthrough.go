@@ -0,0 +1,153 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/sirupsen/logrus"
+)
+
+// TLSPassthrough routes TLS connections by the server name of their ClientHello, without terminating them.
+// With nets.SocketsDialer, "example.com" is served by the client publishing /example.com/443.
+type TLSPassthrough struct {
+	HTTP
+
+	Dialer           nets.NetDialer
+	Director         func(serverName string) string // returns the target to dial, default serverName:443
+	HandshakeTimeout time.Duration                  // default 10s
+}
+
+func (s *TLSPassthrough) Run(ctx context.Context) error {
+	l, err := s.listen()
+	if err != nil {
+		return err
+	}
+	ctx = nets.ContextWithServerName(ctx, "TLSPassthrough["+s.Address+"]")
+	return nets.RunNetServer(ctx, &passthroughServer{s: s, conns: make(map[net.Conn]struct{})}, l)
+}
+
+type passthroughServer struct {
+	s *TLSPassthrough
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+func (p *passthroughServer) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	p.listener = l
+	p.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+
+		p.mu.Lock()
+		p.conns[c] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go func() {
+			defer p.wg.Done()
+			p.handle(c)
+			p.mu.Lock()
+			delete(p.conns, c)
+			p.mu.Unlock()
+		}()
+	}
+}
+
+func (p *passthroughServer) ListenAndServe() error {
+	l, err := p.s.listen()
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+func (p *passthroughServer) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	if p.listener != nil {
+		_ = p.listener.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for c := range p.conns {
+			_ = c.Close()
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (p *passthroughServer) handle(c net.Conn) {
+	defer c.Close()
+
+	timeout := p.s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	serverName, conn, err := nets.PeekServerName(c)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		logrus.Debugf("Cannot read ClientHello from %v: %v", c.RemoteAddr(), err)
+		return
+	}
+	if !nets.ValidServerName(serverName) {
+		logrus.Debugf("Invalid server name %q from %v", serverName, c.RemoteAddr())
+		return
+	}
+	// Server names are case-insensitive, but the published targets are not.
+	serverName = strings.ToLower(serverName)
+
+	target := net.JoinHostPort(serverName, "443")
+	if p.s.Director != nil {
+		target = p.s.Director(serverName)
+		if target == "" {
+			return
+		}
+	}
+	dialer := p.s.Dialer
+	if dialer == nil {
+		dialer = nets.DefaultNetDialer
+	}
+	upstream, err := dialer.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		logrus.Infof("Cannot pass TLS for %v through to %v: %v", serverName, target, err)
+		return
+	}
+	defer upstream.Close()
+
+	_ = nets.HandleConnections(conn, upstream)
+}
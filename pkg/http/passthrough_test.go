@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+)
+
+func TestPassthroughTarget(t *testing.T) {
+	for serverName, want := range map[string]string{
+		"example.com": "example.com:443",
+		"Example.COM": "example.com:443",
+	} {
+		dialed := make(chan string, 1)
+		p := &passthroughServer{s: &TLSPassthrough{
+			Dialer: nets.NetDialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed <- addr
+				return nil, errors.New("not dialing in tests")
+			}),
+		}}
+
+		c, s := net.Pipe()
+		go func() {
+			_ = tls.Client(c, &tls.Config{ServerName: serverName}).Handshake()
+		}()
+		p.handle(s)
+		_ = c.Close()
+
+		select {
+		case got := <-dialed:
+			if got != want {
+				t.Errorf("%q is passed through to %q, want %q", serverName, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q is not passed through", serverName)
+		}
+	}
+}
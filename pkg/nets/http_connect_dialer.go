@@ -0,0 +1,131 @@
+package nets
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// HTTPConnectDialer dials TCP connections through the HTTP proxy at proxyURL, itself reached with forward.
+// An https proxy URL talks TLS to the proxy, and user info in the URL is sent as basic authentication.
+func HTTPConnectDialer(proxyURL *url.URL, forward NetDialer) NetDialer {
+	if forward == nil {
+		forward = DefaultNetDialer
+	}
+	address := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	return NetDialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return nil, fmt.Errorf("http connect: network %v is not supported", network)
+		}
+
+		conn, err := forward.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL.Scheme == "https" {
+			conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		}
+
+		var ret net.Conn
+		err = handshake(ctx, conn, func() error {
+			req := &http.Request{
+				Method: http.MethodConnect,
+				URL:    &url.URL{Opaque: addr},
+				Host:   addr,
+				Header: make(http.Header),
+			}
+			if u := proxyURL.User; u != nil {
+				password, _ := u.Password()
+				credential := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+				req.Header.Set("Proxy-Authorization", "Basic "+credential)
+			}
+			if err := req.Write(conn); err != nil {
+				return err
+			}
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, req)
+			if err != nil {
+				return err
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("connect %v: %v", addr, resp.Status)
+			}
+			ret = &bufferedConn{Conn: conn, r: r}
+			return nil
+		})
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("http proxy %v: %w", address, err)
+		}
+		return ret, nil
+	})
+}
+
+// ProxyURLDialer dials through the proxy at proxyURL, with scheme socks5, socks5h, http or https.
+func ProxyURLDialer(proxyURL *url.URL, forward NetDialer) (NetDialer, error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *SOCKS5Auth
+		if u := proxyURL.User; u != nil {
+			password, _ := u.Password()
+			auth = &SOCKS5Auth{User: u.Username(), Password: password}
+		}
+		address := proxyURL.Host
+		if proxyURL.Port() == "" {
+			address = net.JoinHostPort(proxyURL.Hostname(), "1080")
+		}
+		return SOCKS5Dialer(address, auth, forward), nil
+
+	case "http", "https":
+		return HTTPConnectDialer(proxyURL, forward), nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// EnvironmentProxyDialer dials through the proxy that HTTPS_PROXY and NO_PROXY choose for each address,
+// or directly with forward when there is none.
+func EnvironmentProxyDialer(forward NetDialer) NetDialer {
+	if forward == nil {
+		forward = DefaultNetDialer
+	}
+	return NetDialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil {
+			return forward.DialContext(ctx, network, addr)
+		}
+		d, err := ProxyURLDialer(proxyURL, forward)
+		if err != nil {
+			return nil, err
+		}
+		return d.DialContext(ctx, network, addr)
+	})
+}
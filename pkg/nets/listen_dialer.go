@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 )
 
 type listenDialer chan net.Conn
@@ -55,6 +56,89 @@ func ListenDialerWithBuffer(size int) (net.Listener, NetDialer) {
 	return ld, ld
 }
 
+// connListener is a listener for connections accepted elsewhere, which blocks deliveries until accepted.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) deliver(c net.Conn) bool {
+	select {
+	case l.conns <- c:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// MergeListeners accepts connections from all listeners, until it is closed or the first of them fails.
+// Closing it closes all of them.
+func MergeListeners(ls ...net.Listener) net.Listener {
+	if len(ls) == 1 {
+		return ls[0]
+	}
+	merged := &mergedListener{connListener: newConnListener(ls[0].Addr()), ls: ls}
+	for _, l := range ls {
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					_ = merged.Close()
+					return
+				}
+				if !merged.deliver(c) {
+					_ = c.Close()
+					return
+				}
+			}
+		}()
+	}
+	return merged
+}
+
+type mergedListener struct {
+	*connListener
+	ls []net.Listener
+}
+
+func (l *mergedListener) Close() error {
+	_ = l.connListener.Close()
+	for _, l := range l.ls {
+		_ = l.Close()
+	}
+	return nil
+}
+
 func HandleListener(l net.Listener, h func(net.Conn)) error {
 	for {
 		c, err := l.Accept()
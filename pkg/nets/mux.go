@@ -0,0 +1,142 @@
+package nets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Protocol string
+
+const (
+	ProtocolSSH  Protocol = "ssh"
+	ProtocolTLS  Protocol = "tls"
+	ProtocolHTTP Protocol = "http" // anything that is neither SSH nor TLS
+)
+
+// sniff tells the protocol from the first bytes of a connection, or asks for more.
+func sniff(b []byte) (Protocol, bool) {
+	if len(b) == 0 {
+		return "", false
+	}
+	if b[0] == 0x16 { // TLS handshake record
+		return ProtocolTLS, true
+	}
+	prefix := []byte("SSH-")
+	if len(b) < len(prefix) {
+		if bytes.HasPrefix(prefix, b) {
+			return "", false
+		}
+		return ProtocolHTTP, true
+	}
+	if bytes.HasPrefix(b, prefix) {
+		return ProtocolSSH, true
+	}
+	return ProtocolHTTP, true
+}
+
+// Mux shares one listener between protocols, by sniffing the first bytes of every connection.
+// Clients must speak first, which SSH, TLS and HTTP clients do.
+type Mux struct {
+	SniffTimeout time.Duration // default 10s
+
+	l         net.Listener
+	listeners map[Protocol]*connListener
+	mu        sync.Mutex
+}
+
+func NewMux(l net.Listener) *Mux {
+	return &Mux{
+		l:         l,
+		listeners: make(map[Protocol]*connListener),
+	}
+}
+
+// Listener returns the listener receiving connections of protocol p.
+// Connections of protocols without a listener are closed.
+func (m *Mux) Listener(p Protocol) net.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ml, ok := m.listeners[p]; ok {
+		return ml
+	}
+	ml := newConnListener(m.l.Addr())
+	m.listeners[p] = ml
+	return ml
+}
+
+// Serve accepts connections until the listener is closed.
+func (m *Mux) Serve() error {
+	defer func() {
+		m.mu.Lock()
+		for _, ml := range m.listeners {
+			_ = ml.Close()
+		}
+		m.mu.Unlock()
+	}()
+
+	for {
+		c, err := m.l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go m.dispatch(c)
+	}
+}
+
+func (m *Mux) Close() error {
+	return m.l.Close()
+}
+
+// Run serves until ctx is done.
+func (m *Mux) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = m.Close()
+	})
+	defer stop()
+	return m.Serve()
+}
+
+func (m *Mux) dispatch(c net.Conn) {
+	timeout := m.SniffTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 0, 8)
+	var p Protocol
+	for {
+		n, err := c.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		var ok bool
+		if p, ok = sniff(buf); ok {
+			break
+		}
+		if err != nil {
+			if err != io.EOF {
+				logrus.Debugf("Cannot sniff protocol of %v: %v", c.RemoteAddr(), err)
+			}
+			_ = c.Close()
+			return
+		}
+	}
+	_ = c.SetReadDeadline(time.Time{})
+
+	m.mu.Lock()
+	ml, ok := m.listeners[p]
+	m.mu.Unlock()
+	if !ok || !ml.deliver(PrefixConn(c, buf)) {
+		logrus.Debugf("No listener for %v connection from %v", p, c.RemoteAddr())
+		_ = c.Close()
+	}
+}
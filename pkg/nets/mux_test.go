@@ -0,0 +1,225 @@
+package nets
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSniff(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Protocol
+		ok   bool
+	}{
+		{"", "", false},
+		{"S", "", false},
+		{"SSH", "", false},
+		{"SSH-", ProtocolSSH, true},
+		{"SSH-2.0-", ProtocolSSH, true},
+		{"\x16", ProtocolTLS, true},
+		{"\x16\x03\x01\x02", ProtocolTLS, true},
+		{"G", ProtocolHTTP, true},
+		{"GET / HT", ProtocolHTTP, true},
+		{"SSX", ProtocolHTTP, true},
+		{"SSH_", ProtocolHTTP, true},
+		{"ssh-", ProtocolHTTP, true},
+	} {
+		p, ok := sniff([]byte(tc.in))
+		if p != tc.want || ok != tc.ok {
+			t.Errorf("sniff(%q) = %q, %v, want %q, %v", tc.in, p, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func startMux(t *testing.T, protocols ...Protocol) (*Mux, map[Protocol]net.Listener) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(l)
+	m.SniffTimeout = 200 * time.Millisecond
+	listeners := make(map[Protocol]net.Listener)
+	for _, p := range protocols {
+		listeners[p] = m.Listener(p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run = %v", err)
+		}
+	})
+	return m, listeners
+}
+
+// send dials the mux and writes chunks one by one, so the sniffer sees partial prefixes.
+func send(t *testing.T, m *Mux, chunks ...string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", m.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	for _, chunk := range chunks {
+		if _, err := c.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c
+}
+
+func TestMuxDispatch(t *testing.T) {
+	m, listeners := startMux(t, ProtocolSSH, ProtocolTLS, ProtocolHTTP)
+
+	for _, tc := range []struct {
+		protocol Protocol
+		chunks   []string
+	}{
+		{ProtocolSSH, []string{"SSH-2.0-test\r\n"}},
+		{ProtocolSSH, []string{"S", "SH", "-2.0-test\r\n"}},
+		{ProtocolTLS, []string{"\x16\x03\x01\x00\x05hello"}},
+		{ProtocolHTTP, []string{"GET / HTTP/1.1\r\n\r\n"}},
+		{ProtocolHTTP, []string{"SS", "X"}},
+	} {
+		c := send(t, m, tc.chunks...)
+		_ = c.(*net.TCPConn).CloseWrite()
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := listeners[tc.protocol].Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		var conn net.Conn
+		select {
+		case conn = <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q is not accepted as %v", tc.chunks, tc.protocol)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(conn)
+		_ = conn.Close()
+		if err != nil {
+			t.Fatalf("read %v connection: %v", tc.protocol, err)
+		}
+		want := ""
+		for _, chunk := range tc.chunks {
+			want += chunk
+		}
+		if string(got) != want {
+			t.Errorf("%v connection yields %q, want %q", tc.protocol, got, want)
+		}
+	}
+}
+
+func TestMuxCloses(t *testing.T) {
+	m, _ := startMux(t, ProtocolSSH)
+
+	for name, chunks := range map[string][]string{
+		"no listener": {"GET / HTTP/1.1\r\n\r\n"},
+		"silent":      nil,
+		"partial":     {"SS"},
+	} {
+		c := send(t, m, chunks...)
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%v: connection is not closed", name)
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Errorf("%v: connection is left open", name)
+		}
+	}
+}
+
+func TestMuxRunStops(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(l)
+	ssh := m.Listener(ProtocolSSH)
+	if m.Listener(ProtocolSSH) != ssh {
+		t.Fatal("Listener returns a different listener for the same protocol")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run does not stop with its context")
+	}
+	if _, err := ssh.Accept(); err == nil {
+		t.Fatal("sub-listener accepts after the mux stopped")
+	}
+}
+
+// closedServer stops serving when its listener is closed, and shuts down once it did.
+type closedServer struct {
+	served chan struct{}
+}
+
+func (s closedServer) Serve(l net.Listener) error {
+	defer close(s.served)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		_ = c.Close()
+	}
+}
+
+func (s closedServer) ListenAndServe() error { return nil }
+
+func (s closedServer) Shutdown(ctx context.Context) error {
+	<-s.served
+	return nil
+}
+
+func TestMuxRunStopsServers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(l)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	go func() {
+		done <- RunNetServer(ctx, closedServer{served: make(chan struct{})}, m.Listener(ProtocolHTTP))
+	}()
+	cancel()
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("stopping = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("servers do not stop with their context")
+		}
+	}
+}
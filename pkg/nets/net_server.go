@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/charmbracelet/ssh"
 	"github.com/sirupsen/logrus"
 )
 
@@ -64,7 +65,9 @@ func RunNetServer(ctx context.Context, s NetServer, l net.Listener) error {
 		} else {
 			err = s.Serve(l)
 		}
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		// Listeners of a Mux may be closed by the Mux stopping first.
+		stopping := ctx.Err() != nil && errors.Is(err, net.ErrClosed)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, ssh.ErrServerClosed) && !stopping {
 			logger.Infof("Server run error: %v", err)
 			serverErr = err
 		}
@@ -0,0 +1,75 @@
+package nets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxPacketSize is the largest packet a length prefix can describe.
+const MaxPacketSize = 65535
+
+// WritePacket writes p prefixed with its length, in a single Write.
+func WritePacket(w io.Writer, p []byte) error {
+	if len(p) > MaxPacketSize {
+		return fmt.Errorf("packet of %v bytes is too large", len(p))
+	}
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadPacket reads a packet written by WritePacket into buf, which should hold MaxPacketSize bytes.
+func ReadPacket(r io.Reader, buf []byte) (int, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(header[:]))
+	if n > len(buf) {
+		return 0, fmt.Errorf("packet of %v bytes exceeds the buffer", n)
+	}
+	return io.ReadFull(r, buf[:n])
+}
+
+// HandlePacketConnection relays packets between the packet stream s and the connected UDP socket c,
+// until either side fails.
+func HandlePacketConnection(s io.ReadWriteCloser, c io.ReadWriteCloser) error {
+	errCh := make(chan error, 2)
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, err := ReadPacket(s, buf)
+			if err == nil {
+				_, err = c.Write(buf[:n])
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, err := c.Read(buf)
+			if err == nil {
+				err = WritePacket(s, buf[:n])
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	err := <-errCh
+	_ = s.Close()
+	_ = c.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
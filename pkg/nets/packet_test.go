@@ -0,0 +1,114 @@
+package nets
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	packets := [][]byte{
+		[]byte("hello"),
+		{},
+		bytes.Repeat([]byte{0xab}, 1500),
+		bytes.Repeat([]byte{0xcd}, MaxPacketSize),
+	}
+
+	var stream bytes.Buffer
+	for _, p := range packets {
+		if err := WritePacket(&stream, p); err != nil {
+			t.Fatalf("WritePacket(%v bytes): %v", len(p), err)
+		}
+	}
+
+	buf := make([]byte, MaxPacketSize)
+	for _, want := range packets {
+		n, err := ReadPacket(&stream, buf)
+		if err != nil {
+			t.Fatalf("ReadPacket: %v", err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Fatalf("ReadPacket = %v bytes, want %v bytes", n, len(want))
+		}
+	}
+	if _, err := ReadPacket(&stream, buf); err != io.EOF {
+		t.Fatalf("ReadPacket at end = %v, want EOF", err)
+	}
+}
+
+func TestWritePacketTooLarge(t *testing.T) {
+	var stream bytes.Buffer
+	if err := WritePacket(&stream, make([]byte, MaxPacketSize+1)); err == nil {
+		t.Fatal("WritePacket accepted an oversized packet")
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("WritePacket wrote %v bytes for a rejected packet", stream.Len())
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	var stream bytes.Buffer
+	_ = WritePacket(&stream, []byte("too long"))
+	if _, err := ReadPacket(&stream, make([]byte, 4)); err == nil {
+		t.Fatal("ReadPacket accepted a packet larger than the buffer")
+	}
+
+	if _, err := ReadPacket(bytes.NewReader([]byte{0, 5, 'a', 'b'}), make([]byte, 16)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadPacket of a truncated packet = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestHandlePacketConnection(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	udp, err := net.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, remote := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- HandlePacketConnection(remote, udp)
+	}()
+
+	_ = local.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, MaxPacketSize)
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := WritePacket(local, []byte(msg)); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+		n, err := ReadPacket(local, buf)
+		if err != nil {
+			t.Fatalf("ReadPacket: %v", err)
+		}
+		if got := string(buf[:n]); got != msg {
+			t.Fatalf("echo = %q, want %q", got, msg)
+		}
+	}
+
+	_ = local.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("HandlePacketConnection = %v, want nil after the stream ends", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandlePacketConnection did not return after the stream closed")
+	}
+}
@@ -0,0 +1,77 @@
+package nets
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// PrefixConn returns c as if prefix was not read from it yet.
+func PrefixConn(c net.Conn, prefix []byte) net.Conn {
+	if len(prefix) == 0 {
+		return c
+	}
+	return &prefixConn{Conn: c, r: io.MultiReader(bytes.NewReader(prefix), c)}
+}
+
+type recordingConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+var errHelloRead = errors.New("client hello read")
+
+// PeekServerName reads the TLS ClientHello of c, and returns its server name with a conn
+// that still yields every byte, so the TLS session can be passed on untouched.
+func PeekServerName(c net.Conn) (string, net.Conn, error) {
+	var buf bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(&recordingConn{Conn: c, r: io.TeeReader(c, &buf)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	conn := PrefixConn(c, buf.Bytes())
+	if hello == nil {
+		return "", conn, fmt.Errorf("read client hello: %w", err)
+	}
+	return hello.ServerName, conn, nil
+}
+
+// ValidServerName reports whether name is a plain DNS name, safe to use in socket file names.
+func ValidServerName(name string) bool {
+	if name == "" || len(name) > 253 || name[0] == '.' || name[0] == '-' {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '.' || r == '_':
+		default:
+			return false
+		}
+	}
+	return !strings.Contains(name, "..")
+}
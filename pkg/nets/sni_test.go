@@ -0,0 +1,126 @@
+package nets
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello returns the first TLS record a client sends with config.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		_ = tls.Client(c, config).Handshake()
+		_ = c.Close()
+	}()
+
+	_ = s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatalf("read record header: %v", err)
+	}
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	if _, err := io.ReadFull(s, record[5:]); err != nil {
+		t.Fatalf("read record: %v", err)
+	}
+	return record
+}
+
+// peek runs PeekServerName on data written in chunks of chunkSize bytes, followed by trailer.
+func peek(t *testing.T, data []byte, chunkSize int, trailer []byte) (string, []byte, error) {
+	t.Helper()
+	c, s := net.Pipe()
+	go func() {
+		for b := data; len(b) > 0; {
+			n := min(chunkSize, len(b))
+			if _, err := c.Write(b[:n]); err != nil {
+				return
+			}
+			b = b[n:]
+		}
+		_, _ = c.Write(trailer)
+		_ = c.Close()
+	}()
+
+	_ = s.SetDeadline(time.Now().Add(5 * time.Second))
+	name, conn, err := PeekServerName(s)
+	rest, _ := io.ReadAll(conn)
+	return name, rest, err
+}
+
+func TestPeekServerName(t *testing.T) {
+	hello := clientHello(t, &tls.Config{ServerName: "Example.com"})
+	trailer := []byte("more")
+
+	for _, chunkSize := range []int{len(hello), 1, 3, 7, 100} {
+		name, rest, err := peek(t, hello, chunkSize, trailer)
+		if err != nil {
+			t.Fatalf("chunks of %v: PeekServerName: %v", chunkSize, err)
+		}
+		if name != "Example.com" {
+			t.Errorf("chunks of %v: server name = %q, want %q", chunkSize, name, "Example.com")
+		}
+		if want := append(append([]byte{}, hello...), trailer...); !bytes.Equal(rest, want) {
+			t.Errorf("chunks of %v: conn yields %v bytes, want the %v bytes sent", chunkSize, len(rest), len(want))
+		}
+	}
+}
+
+func TestPeekServerNameWithoutSNI(t *testing.T) {
+	for _, config := range []*tls.Config{
+		{InsecureSkipVerify: true},
+		{ServerName: "127.0.0.1"}, // IP addresses are not sent as server names
+	} {
+		hello := clientHello(t, config)
+		name, rest, err := peek(t, hello, 5, nil)
+		if err != nil {
+			t.Fatalf("PeekServerName: %v", err)
+		}
+		if name != "" {
+			t.Errorf("server name = %q, want none", name)
+		}
+		if ValidServerName(name) {
+			t.Errorf("ValidServerName(%q) = true", name)
+		}
+		if !bytes.Equal(rest, hello) {
+			t.Errorf("conn yields %v bytes, want the %v bytes sent", len(rest), len(hello))
+		}
+	}
+}
+
+func TestPeekServerNameNotTLS(t *testing.T) {
+	data := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if _, rest, err := peek(t, data, 4, nil); err == nil {
+		t.Fatal("PeekServerName accepted a plain HTTP request")
+	} else if !bytes.HasPrefix(data, rest) || len(rest) == 0 {
+		t.Fatalf("conn yields %q, want a prefix of the request", rest)
+	}
+}
+
+func TestValidServerName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"example.com":     true,
+		"Example.COM":     true,
+		"a-b_c.example":   true,
+		"":                false,
+		".example.com":    false,
+		"-example.com":    false,
+		"example..com":    false,
+		"example.com/x":   false,
+		"../etc":          false,
+		"exa mple.com":    false,
+		"example.com:443": false,
+		"ünicode.example": false,
+	} {
+		if got := ValidServerName(name); got != want {
+			t.Errorf("ValidServerName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package nets
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol: https://www.rfc-editor.org/rfc/rfc1928 and https://www.rfc-editor.org/rfc/rfc1929
+
+type SOCKS5Auth struct {
+	User     string
+	Password string
+}
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// SOCKS5Dialer dials TCP connections through the SOCKS5 server at address, itself reached with forward.
+// Host names are resolved by the SOCKS5 server.
+func SOCKS5Dialer(address string, auth *SOCKS5Auth, forward NetDialer) NetDialer {
+	if forward == nil {
+		forward = DefaultNetDialer
+	}
+	return NetDialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return nil, fmt.Errorf("socks5: network %v is not supported", network)
+		}
+
+		conn, err := forward.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		err = handshake(ctx, conn, func() error {
+			return socks5Connect(conn, addr, auth)
+		})
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("socks5 %v: %w", address, err)
+		}
+		return conn, nil
+	})
+}
+
+func socks5Connect(conn net.Conn, addr string, auth *SOCKS5Auth) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portString)
+	}
+
+	methods := []byte{0x00}
+	if auth != nil {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != 0x05 {
+		return fmt.Errorf("unexpected version %v", buf[0])
+	}
+	switch buf[1] {
+	case 0x00:
+	case 0x02:
+		if auth == nil {
+			return fmt.Errorf("authentication required")
+		}
+		if len(auth.User) > 255 || len(auth.Password) > 255 {
+			return fmt.Errorf("user or password too long")
+		}
+		req := []byte{0x01, byte(len(auth.User))}
+		req = append(req, auth.User...)
+		req = append(req, byte(len(auth.Password)))
+		req = append(req, auth.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return fmt.Errorf("authentication failed")
+		}
+	default:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		if int(reply[1]) < len(socks5Errors) {
+			return fmt.Errorf("connect %v: %v", addr, socks5Errors[reply[1]])
+		}
+		return fmt.Errorf("connect %v: error %v", addr, reply[1])
+	}
+
+	// Skip the bound address.
+	var skip int
+	switch reply[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("unexpected address type %v", reply[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -21,14 +21,33 @@ func NetSSHDialer(netDialer NetDialer) SSHDialer {
 		netDialer = DefaultNetDialer
 	}
 	return SSHDialerFunc(func(ctx context.Context, network, addr string, config *gossh.ClientConfig) (*gossh.Client, error) {
+		// The timeout covers the handshake too, which a stalled server would otherwise hold forever.
+		if config != nil && config.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+			defer cancel()
+		}
 		conn, err := netDialer.DialContext(ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
-		sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, config)
+
+		var client *gossh.Client
+		err = handshake(ctx, conn, func() error {
+			sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, config)
+			if err != nil {
+				return err
+			}
+			client = gossh.NewClient(sshConn, chans, reqs)
+			return nil
+		})
 		if err != nil {
+			if client != nil {
+				_ = client.Close()
+			}
+			_ = conn.Close()
 			return nil, err
 		}
-		return gossh.NewClient(sshConn, chans, reqs), nil
+		return client, nil
 	})
 }
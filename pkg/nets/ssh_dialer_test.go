@@ -0,0 +1,88 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// stalledServer accepts connections and never answers, reporting when a connection is closed.
+func stalledServer(t *testing.T) (string, <-chan struct{}) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	closed := make(chan struct{}, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1<<10)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				closed <- struct{}{}
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), closed
+}
+
+func TestNetSSHDialerHandshakeTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+	}{
+		{"client config timeout", 100 * time.Millisecond, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, context.DeadlineExceeded},
+		{"context deadline", 0, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, context.DeadlineExceeded},
+		{"context canceled", 0, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, closed := stalledServer(t)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := NetSSHDialer(nil).DialContext(ctx, "tcp", address, &gossh.ClientConfig{
+				User:            "test",
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+				Timeout:         tt.timeout,
+			})
+			// A deadline may fire on the connection before the context reports it.
+			timedOut := tt.want == context.DeadlineExceeded && errors.Is(err, os.ErrDeadlineExceeded)
+			if !errors.Is(err, tt.want) && !timedOut {
+				t.Errorf("DialContext = %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("DialContext took %v", elapsed)
+			}
+			select {
+			case <-closed:
+			case <-time.After(2 * time.Second):
+				t.Error("connection is left open")
+			}
+		})
+	}
+}
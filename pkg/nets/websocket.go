@@ -0,0 +1,431 @@
+package nets
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 transport: a stream is carried in binary messages, without extensions.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+type websocketConn struct {
+	net.Conn
+	r      *bufio.Reader
+	client bool // clients mask what they send
+
+	// current data frame
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func newWebSocketConn(c net.Conn, r *bufio.Reader, client bool) *websocketConn {
+	return &websocketConn{Conn: c, r: r, client: client}
+}
+
+func (c *websocketConn) readHeader() (opcode byte, length uint64, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.r, h[:]); err != nil {
+		return 0, 0, err
+	}
+	opcode = h[0] & 0x0f
+	c.masked = h[1]&0x80 != 0
+	// Clients must mask every frame, and servers must not.
+	if c.masked == c.client {
+		if c.client {
+			return 0, 0, errors.New("websocket: masked frame from server")
+		}
+		return 0, 0, errors.New("websocket: unmasked frame from client")
+	}
+	length = uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, 0, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	c.maskPos = 0
+	if c.masked {
+		if _, err = io.ReadFull(c.r, c.mask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return opcode, length, nil
+}
+
+func (c *websocketConn) unmask(b []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range b {
+		b[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+func (c *websocketConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		opcode, length, err := c.readHeader()
+		if err != nil {
+			return 0, err
+		}
+
+		switch opcode {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			c.remaining = length
+			continue
+		}
+
+		if length > 125 {
+			return 0, fmt.Errorf("websocket: control frame too long")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return 0, err
+		}
+		c.unmask(payload)
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload)
+			return 0, io.EOF
+		default:
+			return 0, fmt.Errorf("websocket: unknown opcode %v", opcode)
+		}
+	}
+
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	c.unmask(b[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *websocketConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *websocketConn) Close() error {
+	// 1000, normal closure, without waiting long for a peer that does not read
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.writeFrame(wsOpClose, []byte{0x03, 0xe8})
+	return c.Conn.Close()
+}
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forwardedConn reports the client address a trusted proxy forwarded.
+type forwardedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c forwardedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// parseIPNets parses IPs and CIDRs, ignoring invalid ones.
+func parseIPNets(items []string) []*net.IPNet {
+	ret := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(item); err == nil {
+			ret = append(ret, ipnet)
+		}
+	}
+	return ret
+}
+
+func containsIP(ipnets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range ipnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the address of the client behind the trusted proxies, from X-Forwarded-For.
+// Proxies append the address they received a request from, so it is the last untrusted one.
+func forwardedFor(r *http.Request, remote net.Addr, trusted []*net.IPNet) net.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return remote
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(trusted, ip) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		client = hop
+		if !containsIP(trusted, hop) {
+			break
+		}
+	}
+	if client == nil {
+		return remote
+	}
+	return &net.TCPAddr{IP: client}
+}
+
+// WebSocketListener returns a listener for the WebSocket connections upgraded by the handler,
+// so stream servers like SSH can be reached through HTTP(S).
+// Connections from trustedProxies, IPs or CIDRs, report the client address of their X-Forwarded-For header,
+// instead of the address of the proxy.
+func WebSocketListener(addr net.Addr, trustedProxies ...string) (net.Listener, http.Handler) {
+	l := newConnListener(addr)
+	trusted := parseIPNets(trustedProxies)
+	return l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if r.Method != http.MethodGet || key == "" ||
+			!headerContainsToken(r.Header, "Connection", "upgrade") ||
+			!headerContainsToken(r.Header, "Upgrade", "websocket") {
+			http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "WebSocket is not supported over this connection", http.StatusInternalServerError)
+			return
+		}
+
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+		_ = conn.SetDeadline(time.Time{})
+		if len(trusted) > 0 {
+			conn = forwardedConn{Conn: conn, remote: forwardedFor(r, conn.RemoteAddr(), trusted)}
+		}
+		_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %v\r\n\r\n", wsAccept(key))
+		if err == nil {
+			err = rw.Flush()
+		}
+		if err != nil || !l.deliver(newWebSocketConn(conn, rw.Reader, false)) {
+			_ = conn.Close()
+		}
+	})
+}
+
+// WebSocketDialer connects to the ws:// or wss:// URL through forward, whatever address it is asked for.
+// User info in the URL is sent as basic authentication, and tlsConfig may be nil for wss.
+func WebSocketDialer(wsURL *url.URL, tlsConfig *tls.Config, forward NetDialer) NetDialer {
+	if forward == nil {
+		forward = DefaultNetDialer
+	}
+
+	return NetDialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var secure bool
+		switch wsURL.Scheme {
+		case "ws", "http":
+		case "wss", "https":
+			secure = true
+		default:
+			return nil, fmt.Errorf("websocket: unsupported scheme %q", wsURL.Scheme)
+		}
+		address := wsURL.Host
+		if wsURL.Port() == "" {
+			port := "80"
+			if secure {
+				port = "443"
+			}
+			address = net.JoinHostPort(wsURL.Hostname(), port)
+		}
+
+		conn, err := forward.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		if secure {
+			config := &tls.Config{}
+			if tlsConfig != nil {
+				config = tlsConfig.Clone()
+			}
+			if config.ServerName == "" {
+				config.ServerName = wsURL.Hostname()
+			}
+			conn = tls.Client(conn, config)
+		}
+
+		var ret net.Conn
+		err = handshake(ctx, conn, func() error {
+			var nonce [16]byte
+			if _, err := rand.Read(nonce[:]); err != nil {
+				return err
+			}
+			key := base64.StdEncoding.EncodeToString(nonce[:])
+
+			u := *wsURL
+			u.Scheme = "http"
+			if secure {
+				u.Scheme = "https"
+			}
+			req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Sec-WebSocket-Key", key)
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			if u := wsURL.User; u != nil {
+				password, _ := u.Password()
+				req.SetBasicAuth(u.Username(), password)
+			}
+			if err := req.Write(conn); err != nil {
+				return err
+			}
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, req)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				_ = resp.Body.Close()
+				return fmt.Errorf("upgrade %v: %v", wsURL.Redacted(), resp.Status)
+			}
+			if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+				return errors.New("invalid Sec-WebSocket-Accept")
+			}
+			ret = newWebSocketConn(conn, r, true)
+			return nil
+		})
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("websocket %v: %w", address, err)
+		}
+		return ret, nil
+	})
+}
@@ -0,0 +1,353 @@
+package nets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rawFrame encodes a frame, masked when mask is given.
+func rawFrame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	b := []byte{opcode}
+	if fin {
+		b[0] |= 0x80
+	}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	b = append(b, mask...)
+	for i, c := range payload {
+		if mask != nil {
+			c ^= mask[i&3]
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// readRawFrame decodes a frame, unmasking it.
+func readRawFrame(t *testing.T, r io.Reader) (fin bool, opcode byte, masked bool, payload []byte) {
+	t.Helper()
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	masked = h[1]&0x80 != 0
+	var mask [4]byte
+	if masked {
+		_, _ = io.ReadFull(r, mask[:])
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("read frame payload: %v", err)
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i&3]
+		}
+	}
+	return h[0]&0x80 != 0, h[0] & 0x0f, masked, payload
+}
+
+// rawPeer returns the websocket end of a pipe, and the raw end to speak frames on.
+func rawPeer(t *testing.T, client bool) (*websocketConn, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	_ = a.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = b.SetDeadline(time.Now().Add(5 * time.Second))
+	return newWebSocketConn(a, bufio.NewReader(a), client), b
+}
+
+func writeAsync(c net.Conn, frames ...[]byte) {
+	go func() {
+		for _, f := range frames {
+			if _, err := c.Write(f); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	wsListener, handler := WebSocketListener(&net.TCPAddr{})
+	defer wsListener.Close()
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ssh" {
+			http.NotFound(w, r)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+	u.Path = "/ssh"
+	u.User = url.UserPassword("user", "secret")
+	client, err := WebSocketDialer(u, nil, nil).DialContext(context.Background(), "tcp", "ignored:22")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	if want := "Basic dXNlcjpzZWNyZXQ="; authorization != want {
+		t.Errorf("Authorization = %q, want %q", authorization, want)
+	}
+
+	server, err := wsListener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer server.Close()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Small, 16-bit and 64-bit lengths, both ways.
+	for _, size := range []int{1, 125, 126, 1000, 0xffff, 70000} {
+		msg := make([]byte, size)
+		_, _ = rand.Read(msg)
+		for _, dir := range []struct {
+			name string
+			w, r net.Conn
+		}{{"client to server", client, server}, {"server to client", server, client}} {
+			go func() {
+				_, _ = dir.w.Write(msg)
+			}()
+			got := make([]byte, size)
+			if _, err := io.ReadFull(dir.r, got); err != nil {
+				t.Fatalf("%v, %v bytes: %v", dir.name, size, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("%v, %v bytes: payload differs", dir.name, size)
+			}
+		}
+	}
+
+	// Closing one end is seen as EOF on the other.
+	go func() {
+		_ = client.Close()
+	}()
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after the peer closed = %v, want EOF", err)
+	}
+}
+
+func TestWebSocketUpgradeRequired(t *testing.T) {
+	wsListener, handler := WebSocketListener(&net.TCPAddr{})
+	defer wsListener.Close()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("plain GET = %v, want %v", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+}
+
+func TestWebSocketMasking(t *testing.T) {
+	client, raw := rawPeer(t, true)
+	msg := []byte("from the client")
+	go func() {
+		_, _ = client.Write(msg)
+	}()
+	_, opcode, masked, payload := readRawFrame(t, raw)
+	if opcode != wsOpBinary || !masked || !bytes.Equal(payload, msg) {
+		t.Fatalf("client frame = opcode %v, masked %v, %q; want a masked binary frame of %q", opcode, masked, payload, msg)
+	}
+
+	server, raw := rawPeer(t, false)
+	msg = []byte("from the server")
+	go func() {
+		_, _ = server.Write(msg)
+	}()
+	_, opcode, masked, payload = readRawFrame(t, raw)
+	if opcode != wsOpBinary || masked || !bytes.Equal(payload, msg) {
+		t.Fatalf("server frame = opcode %v, masked %v, %q; want an unmasked binary frame of %q", opcode, masked, payload, msg)
+	}
+}
+
+func TestWebSocketRejectsWrongMasking(t *testing.T) {
+	server, raw := rawPeer(t, false)
+	writeAsync(raw, rawFrame(true, wsOpBinary, []byte("unmasked"), nil))
+	if _, err := server.Read(make([]byte, 16)); err == nil || err == io.EOF {
+		t.Fatalf("server read of an unmasked frame = %v, want an error", err)
+	}
+
+	client, raw := rawPeer(t, true)
+	writeAsync(raw, rawFrame(true, wsOpBinary, []byte("masked"), []byte{1, 2, 3, 4}))
+	if _, err := client.Read(make([]byte, 16)); err == nil || err == io.EOF {
+		t.Fatalf("client read of a masked frame = %v, want an error", err)
+	}
+}
+
+func TestWebSocketFragmentsAndPing(t *testing.T) {
+	server, raw := rawPeer(t, false)
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	writeAsync(raw,
+		rawFrame(false, wsOpBinary, []byte("frag"), mask),
+		rawFrame(true, wsOpPing, []byte("are you there"), mask), // control frames may come between fragments
+		rawFrame(false, wsOpContinuation, []byte("mented "), mask),
+		rawFrame(true, wsOpContinuation, []byte("message"), mask),
+	)
+
+	pong := make(chan []byte, 1)
+	go func() {
+		_, opcode, masked, payload := readRawFrame(t, raw)
+		if opcode != wsOpPong || masked {
+			t.Errorf("reply to ping = opcode %v, masked %v; want an unmasked pong", opcode, masked)
+		}
+		pong <- payload
+	}()
+
+	want := "fragmented message"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatalf("read fragments: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("fragments = %q, want %q", got, want)
+	}
+	if p := <-pong; string(p) != "are you there" {
+		t.Fatalf("pong payload = %q, want the ping payload", p)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	server, raw := rawPeer(t, false)
+	writeAsync(raw, rawFrame(true, wsOpClose, []byte{0x03, 0xe8}, []byte{9, 8, 7, 6}))
+
+	reply := make(chan byte, 1)
+	go func() {
+		_, opcode, _, _ := readRawFrame(t, raw)
+		reply <- opcode
+	}()
+	if _, err := server.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("read of a close frame = %v, want EOF", err)
+	}
+	if opcode := <-reply; opcode != wsOpClose {
+		t.Fatalf("reply to close = opcode %v, want close", opcode)
+	}
+	if _, err := server.Write([]byte("late")); err == nil {
+		t.Fatal("write after close succeeded")
+	}
+
+	client, raw := rawPeer(t, true)
+	go func() {
+		_ = client.Close()
+	}()
+	_, opcode, masked, payload := readRawFrame(t, raw)
+	if opcode != wsOpClose || !masked || !bytes.Equal(payload, []byte{0x03, 0xe8}) {
+		t.Fatalf("Close sends opcode %v, masked %v, %x; want a masked close frame with 1000", opcode, masked, payload)
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	trusted := parseIPNets([]string{"10.0.0.0/8", "192.0.2.1", "invalid"})
+	for _, tt := range []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "untrusted peer", remote: "203.0.113.9:1234", xff: []string{"198.51.100.1"}, want: "203.0.113.9"},
+		{name: "trusted peer", remote: "10.1.2.3:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted IP", remote: "192.0.2.1:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "no header", remote: "10.1.2.3:1234", want: "10.1.2.3"},
+		{name: "spoofed hops", remote: "10.1.2.3:1234", xff: []string{"127.0.0.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted hops", remote: "10.1.2.3:1234", xff: []string{"198.51.100.1, 10.9.9.9", "192.0.2.1"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.1.2.3:1234", xff: []string{"10.9.9.9"}, want: "10.9.9.9"},
+		{name: "invalid hop", remote: "10.1.2.3:1234", xff: []string{"198.51.100.1, unknown, 10.9.9.9"}, want: "10.9.9.9"},
+		{name: "IPv6", remote: "10.1.2.3:1234", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ssh", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			remote, _ := net.ResolveTCPAddr("tcp", tt.remote)
+			addr := forwardedFor(r, remote, trusted)
+			if got := addr.(*net.TCPAddr).IP.String(); got != tt.want {
+				t.Fatalf("client = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketTrustedProxy(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		trusted []string
+		want    string
+	}{
+		{name: "trusted", trusted: []string{"127.0.0.1"}, want: "198.51.100.1"},
+		{name: "untrusted", trusted: []string{"10.0.0.0/8"}, want: "127.0.0.1"},
+		{name: "none", want: "127.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			wsListener, handler := WebSocketListener(&net.TCPAddr{}, tt.trusted...)
+			defer wsListener.Close()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Set("X-Forwarded-For", "198.51.100.1")
+				handler.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			u.Scheme = "ws"
+			client, err := WebSocketDialer(u, nil, nil).DialContext(context.Background(), "tcp", "ignored:22")
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer client.Close()
+			server, err := wsListener.Accept()
+			if err != nil {
+				t.Fatalf("accept: %v", err)
+			}
+			defer server.Close()
+			if got := server.RemoteAddr().(*net.TCPAddr).IP.String(); got != tt.want {
+				t.Fatalf("RemoteAddr = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,15 @@ type contextKey struct {
 
 var ContextKeyReverseProxyAuthed = &contextKey{"rp_authed"}
 var ContextKeyProxyAuthed = &contextKey{"p_authed"}
+var ContextKeyRoles = &contextKey{"roles"}
 
 type CachedProxyKey struct {
 	Target string
 }
+
+type Role string
+
+const (
+	RoleReverseProxy Role = "reverse-proxy"
+	RoleProxy        Role = "proxy"
+)
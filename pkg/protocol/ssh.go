@@ -1,5 +1,10 @@
 package protocol
 
+import (
+	"net"
+	"strings"
+)
+
 // SSH Protocol: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
 
 const (
@@ -22,9 +27,54 @@ type RemoteForwardChannelData struct {
 	Reserved   string
 }
 
+// UDP forwarding is an SRP extension. Each UDP flow is a channel carrying packets,
+// each one prefixed with its length as a big-endian uint16.
+const (
+	UDPForwardRequestType = "udp-forward@srp"
+	UDPCancelRequestType  = "cancel-udp-forward@srp"
+
+	ForwardedUDPChannelType = "forwarded-udp@srp" // opened by the server to the publishing client
+	DirectUDPChannelType    = "direct-udp@srp"    // opened by a consuming client, with DirectPayload
+)
+
+type UDPForwardRequest struct {
+	Target string // "/host/port", like BindUnixSocket
+}
+
+type UDPForwardCancelRequest struct {
+	Target string
+}
+
+type ForwardedUDPChannelData struct {
+	Target            string
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
 type DirectPayload struct {
 	Host              string
 	Port              uint32
 	OriginatorAddress string
 	OriginatorPort    uint32
 }
+
+// A federated SRP server opens direct-tcpip channels with the originator address set to
+// FederationOriginatorPrefix followed by the comma separated IDs of the servers the channel passed.
+const FederationOriginatorPrefix = "srp-via="
+
+func FederationOriginator(hops []string) string {
+	return FederationOriginatorPrefix + strings.Join(hops, ",")
+}
+
+// FederationHops returns the servers an originator address passed, nil for a regular client.
+func FederationHops(originator string) []string {
+	host, _, err := net.SplitHostPort(originator)
+	if err != nil {
+		host = originator
+	}
+	hops, ok := strings.CutPrefix(host, FederationOriginatorPrefix)
+	if !ok || hops == "" {
+		return nil
+	}
+	return strings.Split(hops, ",")
+}
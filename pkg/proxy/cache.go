@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCachePositiveTTL = time.Minute
+	DefaultCacheNegativeTTL = 5 * time.Second
+	DefaultCacheMaxEntries  = 4096
+)
+
+type cacheEntry struct {
+	proxy   Proxy
+	err     error
+	expires time.Time
+}
+
+// ProxyCache keeps provider results per target, for a session or shared by all of them.
+// Reverse proxy events should invalidate the targets they concern, see InvalidateHostPort.
+type ProxyCache struct {
+	PositiveTTL time.Duration
+	NegativeTTL time.Duration
+	// MaxEntries bounds the memory clients can make the cache use by asking for many targets.
+	// Expired entries are dropped first, then the ones expiring soonest. Default DefaultCacheMaxEntries.
+	MaxEntries int
+
+	entries map[string]cacheEntry
+	mu      sync.Mutex
+}
+
+func NewProxyCache(positiveTTL, negativeTTL time.Duration) *ProxyCache {
+	return &ProxyCache{
+		PositiveTTL: positiveTTL,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]cacheEntry),
+	}
+}
+
+// Get returns the cached result for target, and whether there is one.
+func (c *ProxyCache) Get(target string) (Proxy, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[target]
+	if !ok {
+		return nil, nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, target)
+		return nil, nil, false
+	}
+	return e.proxy, e.err, true
+}
+
+// Set caches a result for target. A zero TTL disables caching of that kind of result.
+func (c *ProxyCache) Set(target string, proxy Proxy, err error) {
+	ttl := c.PositiveTTL
+	if err != nil {
+		ttl = c.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	if _, ok := c.entries[target]; !ok {
+		c.makeRoomLocked(now)
+	}
+	c.entries[target] = cacheEntry{proxy: proxy, err: err, expires: now.Add(ttl)}
+}
+
+func (c *ProxyCache) makeRoomLocked(now time.Time) {
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	if len(c.entries) < maxEntries {
+		return
+	}
+	for target, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, target)
+		}
+	}
+	for len(c.entries) >= maxEntries {
+		var soonest string
+		var soonestExpires time.Time
+		for target, e := range c.entries {
+			if soonestExpires.IsZero() || e.expires.Before(soonestExpires) {
+				soonest, soonestExpires = target, e.expires
+			}
+		}
+		delete(c.entries, soonest)
+	}
+}
+
+func (c *ProxyCache) Invalidate(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, target)
+}
+
+// InvalidateHostPort fits reverseproxy.EventHandler callbacks.
+func (c *ProxyCache) InvalidateHostPort(host string, port string) {
+	c.Invalidate(net.JoinHostPort(host, port))
+}
+
+func (c *ProxyCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// FallbackProxy dials the proxies in order until one succeeds.
+func FallbackProxy(proxies ...Proxy) Proxy {
+	return wrapProxy(func(ctx context.Context) (net.Conn, error) {
+		errs := make([]error, 0, len(proxies))
+		for _, p := range proxies {
+			c, err := p.Dial(ctx)
+			if err == nil {
+				return c, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	}, proxies...)
+}
+
+// FallbackProxyProvider asks every provider for target, and dials the results in order until one succeeds.
+// Providers that cannot provide target are skipped.
+// Combine it with ProxyProviderWithTimeout so a slow provider does not hold back the next one.
+func FallbackProxyProvider(providers ...ProxyProvider) ProxyProvider {
+	return ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		proxies := make([]Proxy, 0, len(providers))
+		errs := make([]error, 0)
+		for _, p := range providers {
+			proxy, err := p.ProxyProvide(ctx, target)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			proxies = append(proxies, proxy)
+		}
+		if len(proxies) == 0 {
+			if len(errs) == 0 {
+				return nil, fmt.Errorf("no provider for %v", target)
+			}
+			return nil, errors.Join(errs...)
+		}
+		if len(proxies) == 1 {
+			return proxies[0], nil
+		}
+		return FallbackProxy(proxies...), nil
+	})
+}
+
+// ProxyProviderWithRewrite hands the rewritten target to p.
+func ProxyProviderWithRewrite(p ProxyProvider, rewrite func(target string) (string, error)) ProxyProvider {
+	return ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		rewritten, err := rewrite(target)
+		if err != nil {
+			return nil, err
+		}
+		return p.ProxyProvide(ctx, rewritten)
+	})
+}
+
+type ProxyRoute struct {
+	Pattern  string // target glob, a pattern without port matches every port
+	Provider ProxyProvider
+}
+
+type compiledRoute struct {
+	glob     glob.Glob
+	provider ProxyProvider
+}
+
+// RoutedProxyProvider hands a target to the provider of the first matching route.
+func RoutedProxyProvider(routes ...ProxyRoute) (ProxyProvider, error) {
+	compiled := make([]compiledRoute, 0, len(routes))
+	for _, r := range routes {
+		pattern := r.Pattern
+		if !strings.Contains(pattern, ":") {
+			pattern = pattern + ":*"
+		}
+		g, err := glob.Compile(pattern, '.', ':')
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", r.Pattern, err)
+		}
+		compiled = append(compiled, compiledRoute{glob: g, provider: r.Provider})
+	}
+
+	return ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		for _, r := range compiled {
+			if r.glob.Match(target) {
+				return r.provider.ProxyProvide(ctx, target)
+			}
+		}
+		return nil, fmt.Errorf("no route for %v", target)
+	}), nil
+}
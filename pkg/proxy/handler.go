@@ -1,8 +1,12 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/ssh"
 	"github.com/pigeonligh/srp/pkg/auth"
@@ -17,6 +21,11 @@ type Handler interface {
 	PublicKeyHandler() ssh.PublicKeyHandler
 
 	HandleProxy(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context)
+	HandleUDP(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context)
+
+	// InvalidateTarget drops cached results for a target, e.g. when it is published or removed.
+	// Results cached in sessions expire with their TTL only.
+	InvalidateTarget(host string, port string)
 }
 
 type handler struct {
@@ -25,22 +34,37 @@ type handler struct {
 	provider      ProxyProvider
 	cacheEnabled  bool
 	callbacks     ProxyCallbacks
+
+	cache       *ProxyCache // shared by all sessions, nil unless sharedCache
+	sharedCache bool
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	sessionMu   sync.Mutex
+
+	dialBeforeAccept bool
+	packetDialer     PacketDialer
 }
 
 func New(authenticator auth.Authenticator, authorizer auth.Authorizer, provider ProxyProvider, cacheEnabled bool) Handler {
-	return &handler{
-		authenticator: authenticator,
-		authorizer:    authorizer,
-		provider:      provider,
-		cacheEnabled:  cacheEnabled,
-	}
+	return NewWithOptions(
+		WithAuthenticator(authenticator),
+		WithAuthorizer(authorizer),
+		WithProxyProvider(provider),
+		WithCacheEnabled(cacheEnabled),
+	)
 }
 
 func NewWithOptions(options ...Option) Handler {
-	h := &handler{}
+	h := &handler{
+		positiveTTL: DefaultCachePositiveTTL,
+		negativeTTL: DefaultCacheNegativeTTL,
+	}
 	for _, opt := range options {
 		opt(h)
 	}
+	if h.cacheEnabled && h.sharedCache && h.cache == nil {
+		h.cache = NewProxyCache(h.positiveTTL, h.negativeTTL)
+	}
 	return h
 }
 
@@ -50,12 +74,14 @@ func (h *handler) PasswordHandler() ssh.PasswordHandler {
 		if h.authenticator == nil {
 			ret = true
 		} else {
-			ret = h.authenticator.Authenticate(ctx, auth.AuthenticateRequest{
-				User:     ctx.User(),
-				Password: password,
+			ret = h.authenticator.Authenticate(auth.WithKeyOptionsScope(ctx, string(protocol.RoleProxy)), auth.AuthenticateRequest{
+				User:       ctx.User(),
+				Password:   password,
+				RemoteAddr: ctx.RemoteAddr(),
 			})
 		}
 
+		// pkg/server replaces this with the result of the credential that finished authentication.
 		ctx.SetValue(protocol.ContextKeyProxyAuthed, ret)
 		return ret
 	}
@@ -67,64 +93,137 @@ func (h *handler) PublicKeyHandler() ssh.PublicKeyHandler {
 		if h.authenticator == nil {
 			ret = true
 		} else {
-			ret = h.authenticator.Authenticate(ctx, auth.AuthenticateRequest{
-				User:      ctx.User(),
-				PublicKey: key,
+			ret = h.authenticator.Authenticate(auth.WithKeyOptionsScope(ctx, string(protocol.RoleProxy)), auth.AuthenticateRequest{
+				User:       ctx.User(),
+				PublicKey:  key,
+				RemoteAddr: ctx.RemoteAddr(),
 			})
 		}
 
+		// pkg/server replaces this with the result of the credential that finished authentication.
 		ctx.SetValue(protocol.ContextKeyProxyAuthed, ret)
 		return ret
 	}
 }
 
+type originatorContextKey struct{}
+
+// OriginatorFromContext returns the originator address reported for the channel a provider is asked for.
+func OriginatorFromContext(ctx context.Context) string {
+	originator, _ := ctx.Value(originatorContextKey{}).(string)
+	return originator
+}
+
 func (h *handler) GetProxy(ctx ssh.Context, target string) (Proxy, error) {
+	proxy, _, err := h.getProxy(ctx, target, "")
+	return proxy, err
+}
+
+// getProxy also returns when the access to target expires, zero if it does not.
+func (h *handler) getProxy(ctx ssh.Context, target string, originator string) (Proxy, time.Time, error) {
 	authed, _ := ctx.Value(protocol.ContextKeyProxyAuthed).(bool)
 	if !authed {
-		return nil, fmt.Errorf("unauthenticated for proxy")
+		return nil, time.Time{}, fmt.Errorf("unauthenticated for proxy")
 	}
 
-	var cachedResult any
+	var notAfter time.Time
+	if h.authorizer != nil {
+		var err error
+		notAfter, err = h.authorize(ctx, target, originator)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+
+	if h.provider == nil {
+		return nil, time.Time{}, fmt.Errorf("proxy provider is not set")
+	}
+
+	proxy, err := h.provide(ctx, target, originator)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return proxy, notAfter, nil
+}
+
+type cachedAuthorization struct {
+	err     error // nil when allowed
+	expires time.Time
+}
+
+// authorize caches decisions in the session, as they depend on the user and the connection.
+func (h *handler) authorize(ctx ssh.Context, target string, originator string) (time.Time, error) {
+	cacheKey := protocol.CachedProxyKey{Target: target}
 	if h.cacheEnabled {
-		cacheKey := protocol.CachedProxyKey{Target: target}
-		cachedResult = ctx.Value(cacheKey)
-		if cachedResult != nil {
-			if proxy, ok := cachedResult.(Proxy); ok {
-				return proxy, nil
-			}
-			if err, ok := cachedResult.(error); ok {
-				return nil, err
-			}
+		if cached, ok := ctx.Value(cacheKey).(cachedAuthorization); ok && time.Now().Before(cached.expires) {
+			return time.Time{}, cached.err
 		}
-		defer func() {
-			if cachedResult != nil {
-				ctx.SetValue(cacheKey, cachedResult)
-			}
-		}()
 	}
 
-	if h.authorizer != nil {
-		if !h.authorizer.Authorize(ctx, auth.AuthorizeRequest{
-			User:   ctx.User(),
-			Target: target,
-		}) {
-			err := fmt.Errorf("access denied")
-			cachedResult = err
-			return nil, err
+	req := auth.NewAuthorizeRequest(ctx, target, auth.ActionConnect)
+	req.OriginatorAddress = originator
+	authCtx, expiry := auth.WithExpiry(auth.WithKeyOptionsScope(ctx, string(protocol.RoleProxy)))
+	var err error
+	allowed := h.authorizer.Authorize(authCtx, req)
+	if !allowed {
+		err = fmt.Errorf("access denied")
+	}
+	counted := auth.Decide(authCtx, allowed)
+	notAfter := expiry()
+
+	// Time-limited or counted access must be authorized again for every channel.
+	if h.cacheEnabled && notAfter.IsZero() && !counted {
+		ttl := h.positiveTTL
+		if err != nil {
+			ttl = h.negativeTTL
+		}
+		if ttl > 0 {
+			ctx.SetValue(cacheKey, cachedAuthorization{err: err, expires: time.Now().Add(ttl)})
 		}
 	}
+	return notAfter, err
+}
 
-	if h.provider == nil {
-		return nil, fmt.Errorf("proxy provider is not set")
+type sessionCacheKey struct{}
+
+// providerCache returns the cache for provider results, shared when they depend on the target only,
+// or else kept in the session.
+func (h *handler) providerCache(ctx ssh.Context) *ProxyCache {
+	if h.cache != nil {
+		return h.cache
 	}
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+	cache, _ := ctx.Value(sessionCacheKey{}).(*ProxyCache)
+	if cache == nil {
+		cache = NewProxyCache(h.positiveTTL, h.negativeTTL)
+		ctx.SetValue(sessionCacheKey{}, cache)
+	}
+	return cache
+}
 
-	proxy, err := h.provider.ProxyProvide(ctx, target)
-	if err != nil {
-		cachedResult = err
-		return nil, err
+func (h *handler) provide(ctx ssh.Context, target string, originator string) (Proxy, error) {
+	// Providers may route federated channels by the servers they passed.
+	var cache *ProxyCache
+	if h.cacheEnabled && len(protocol.FederationHops(originator)) == 0 {
+		cache = h.providerCache(ctx)
+		if proxy, err, ok := cache.Get(target); ok {
+			return proxy, err
+		}
+	}
+
+	proxy, err := h.provider.ProxyProvide(context.WithValue(ctx, originatorContextKey{}, originator), target)
+	// An open circuit closes again by itself.
+	if cache != nil && !errors.Is(err, ErrCircuitOpen) {
+		cache.Set(target, proxy, err)
+	}
+	return proxy, err
+}
+
+func (h *handler) InvalidateTarget(host string, port string) {
+	if h.cache != nil {
+		h.cache.InvalidateHostPort(host, port)
 	}
-	cachedResult = proxy
-	return proxy, nil
 }
 
 func (h *handler) HandleProxy(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
@@ -140,9 +239,21 @@ func (h *handler) HandleProxy(srv *ssh.Server, conn *gossh.ServerConn, newChan g
 	}
 	logrus.Infof("Payload for session %v: %v", ctx.SessionID(), payload)
 
-	proxy, err := h.GetProxy(ctx, net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+	target := net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port))
+	proxy, notAfter, err := h.getProxy(
+		ctx,
+		target,
+		net.JoinHostPort(payload.OriginatorAddress, fmt.Sprint(payload.OriginatorPort)),
+	)
+	if err == nil {
+		err = Precheck(proxy)
+	}
 	if err != nil {
-		rejectErr := newChan.Reject(gossh.Prohibited, fmt.Sprintf("Cannot get proxy for session %v: %v", ctx.SessionID(), err))
+		reason := gossh.Prohibited
+		if errors.Is(err, ErrCircuitOpen) {
+			reason = gossh.ConnectionFailed
+		}
+		rejectErr := newChan.Reject(reason, fmt.Sprintf("Cannot get proxy for session %v: %v", ctx.SessionID(), err))
 		if rejectErr != nil {
 			logrus.Errorf("Cannot reject channel for %v: %v", ctx.SessionID(), rejectErr)
 		}
@@ -153,6 +264,21 @@ func (h *handler) HandleProxy(srv *ssh.Server, conn *gossh.ServerConn, newChan g
 	}
 	h.callbacks.OnProxyCreated(ctx, payload)
 
+	// Dialing first lets clients see why the target is unreachable, instead of an open channel closing at once.
+	var c net.Conn
+	if h.dialBeforeAccept {
+		c, err = proxy.Dial(ctx)
+		if err != nil {
+			h.callbacks.OnProxyDialFailed(ctx, payload, err)
+			logrus.Errorf("Cannot dial proxy for %v: %v", ctx.SessionID(), err)
+			if rejectErr := newChan.Reject(gossh.ConnectionFailed, err.Error()); rejectErr != nil {
+				logrus.Errorf("Cannot reject channel for %v: %v", ctx.SessionID(), rejectErr)
+			}
+			return
+		}
+		defer c.Close()
+	}
+
 	ch, _, err := newChan.Accept()
 	if err != nil {
 		h.callbacks.OnProxyChannelAcceptFailed(ctx, payload, err)
@@ -163,13 +289,24 @@ func (h *handler) HandleProxy(srv *ssh.Server, conn *gossh.ServerConn, newChan g
 	h.callbacks.OnProxyChannelAccepted(ctx, payload)
 
 	logrus.Infof("Proxy created for session %v.", ctx.SessionID())
-	c, err := proxy.Dial(ctx)
-	if err != nil {
-		h.callbacks.OnProxyDialFailed(ctx, payload, err)
-		logrus.Errorf("Cannot dial proxy for %v: %v", ctx.SessionID(), err)
-		return
+	if c == nil {
+		c, err = proxy.Dial(ctx)
+		if err != nil {
+			h.callbacks.OnProxyDialFailed(ctx, payload, err)
+			logrus.Errorf("Cannot dial proxy for %v: %v", ctx.SessionID(), err)
+			return
+		}
+		defer c.Close()
 	}
 	h.callbacks.OnProxyDialed(ctx, payload)
+	if !notAfter.IsZero() {
+		t := time.AfterFunc(time.Until(notAfter), func() {
+			logrus.Infof("Access to %v for session %v expired.", target, ctx.SessionID())
+			c.Close()
+			ch.Close()
+		})
+		defer t.Stop()
+	}
 	err = nets.HandleConnections(c, ch)
 	if err != nil {
 		h.callbacks.OnProxyConnectionDone(ctx, payload, err)
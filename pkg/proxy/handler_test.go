@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/pigeonligh/srp/pkg/protocol"
+)
+
+type testContext struct {
+	context.Context
+	sync.Mutex
+
+	values sync.Map
+}
+
+func newTestContext() *testContext {
+	ctx := &testContext{Context: context.Background()}
+	ctx.SetValue(protocol.ContextKeyProxyAuthed, true)
+	return ctx
+}
+
+func (c *testContext) Value(key any) any {
+	if v, ok := c.values.Load(key); ok {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+func (c *testContext) SetValue(key, value any)       { c.values.Store(key, value) }
+func (c *testContext) User() string                  { return "user" }
+func (c *testContext) SessionID() string             { return "session" }
+func (c *testContext) ClientVersion() string         { return "" }
+func (c *testContext) ServerVersion() string         { return "" }
+func (c *testContext) RemoteAddr() net.Addr          { return &net.TCPAddr{} }
+func (c *testContext) LocalAddr() net.Addr           { return &net.TCPAddr{} }
+func (c *testContext) Permissions() *ssh.Permissions { return &ssh.Permissions{} }
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) ProxyProvide(ctx context.Context, target string) (Proxy, error) {
+	p.calls++
+	return Direct("tcp", target), nil
+}
+
+func TestHandlerProviderCache(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options []Option
+		calls   int // for two channels in each of two sessions
+	}{
+		{name: "disabled", calls: 4},
+		{name: "session", options: []Option{WithCacheEnabled(true)}, calls: 2},
+		{name: "shared", options: []Option{WithCacheEnabled(true), WithSharedCache(true)}, calls: 1},
+		{name: "proxy cache", options: []Option{WithProxyCache(NewProxyCache(DefaultCachePositiveTTL, DefaultCacheNegativeTTL))}, calls: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &countingProvider{}
+			h := NewWithOptions(append(tt.options, WithProxyProvider(provider))...).(*handler)
+			for range 2 {
+				ctx := newTestContext()
+				for range 2 {
+					if _, err := h.GetProxy(ctx, "example.com:80"); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if provider.calls != tt.calls {
+				t.Fatalf("provider called %v times, want %v", provider.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestHandlerInvalidateTarget(t *testing.T) {
+	provider := &countingProvider{}
+	h := NewWithOptions(WithProxyProvider(provider), WithSharedCache(true))
+	ctx := newTestContext()
+	for _, target := range []string{"example.com:80", "example.com:80", "example.com:443"} {
+		if _, err := h.(*handler).GetProxy(ctx, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.InvalidateTarget("example.com", "80")
+	for _, target := range []string{"example.com:80", "example.com:443"} {
+		if _, err := h.(*handler).GetProxy(ctx, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if provider.calls != 3 {
+		t.Fatalf("provider called %v times, want 3", provider.calls)
+	}
+}
@@ -1,6 +1,10 @@
 package proxy
 
-import "github.com/pigeonligh/srp/pkg/auth"
+import (
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/auth"
+)
 
 type Option func(*handler)
 
@@ -22,14 +26,57 @@ func WithProxyProvider(provider ProxyProvider) Option {
 	}
 }
 
+// WithCacheEnabled caches authorization and provider results in each session.
 func WithCacheEnabled(enabled bool) Option {
 	return func(h *handler) {
 		h.cacheEnabled = enabled
 	}
 }
 
+// WithCacheTTL sets how long allowed and denied results are cached, when the cache is enabled.
+func WithCacheTTL(positive, negative time.Duration) Option {
+	return func(h *handler) {
+		h.positiveTTL = positive
+		h.negativeTTL = negative
+	}
+}
+
+// WithSharedCache shares cached provider results across sessions, and enables caching.
+// Only use it when the provider results depend on the target alone, not on the user or the connection.
+// Otherwise results are cached per session.
+func WithSharedCache(enabled bool) Option {
+	return func(h *handler) {
+		h.sharedCache = enabled
+		h.cacheEnabled = h.cacheEnabled || enabled
+	}
+}
+
+// WithProxyCache shares cache between handlers, and enables shared caching, see WithSharedCache.
+func WithProxyCache(cache *ProxyCache) Option {
+	return func(h *handler) {
+		h.cache = cache
+		h.sharedCache = true
+		h.cacheEnabled = true
+	}
+}
+
 func WithProxyCallbacks(callbacks ProxyCallbacks) Option {
 	return func(h *handler) {
 		h.callbacks = callbacks
 	}
 }
+
+// WithDialBeforeAccept dials the target before accepting a direct-tcpip channel,
+// and rejects the channel with the dial error when it fails.
+func WithDialBeforeAccept(enabled bool) Option {
+	return func(h *handler) {
+		h.dialBeforeAccept = enabled
+	}
+}
+
+// WithPacketDialer enables UDP forwarding to the targets of d.
+func WithPacketDialer(d PacketDialer) Option {
+	return func(h *handler) {
+		h.packetDialer = d
+	}
+}
@@ -0,0 +1,183 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/fswatch"
+	"github.com/pigeonligh/srp/pkg/proxy"
+	"github.com/sirupsen/logrus"
+)
+
+// Mapping maps virtual targets matching Pattern to Address.
+//
+// In Pattern, "*" matches within a name segment or the port, and "**" matches anything.
+// Address may refer to the matched parts with $1, $2, ... or ${1} when followed by a letter or digit.
+// When Pattern has no port, every port matches and is kept unless Address has its own.
+// An Address starting with "unix:" names a unix socket.
+type Mapping struct {
+	Pattern string
+	Address string
+
+	re       *regexp.Regexp
+	keepPort bool
+}
+
+func NewMapping(pattern string, address string) (Mapping, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString("(.*)")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("([^.:]*)")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	m := Mapping{Pattern: pattern, Address: address}
+	if !strings.Contains(pattern, ":") {
+		b.WriteString(":(?P<port>[0-9]+)")
+		if _, _, err := net.SplitHostPort(address); err != nil && !strings.HasPrefix(address, "unix:") {
+			m.keepPort = true
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return Mapping{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	m.re = re
+	return m, nil
+}
+
+// Map returns the address for target.
+func (m Mapping) Map(target string) (string, bool) {
+	match := m.re.FindStringSubmatchIndex(target)
+	if match == nil {
+		return "", false
+	}
+	address := string(m.re.ExpandString(nil, m.Address, target, match))
+	if m.keepPort {
+		port := string(m.re.ExpandString(nil, "$port", target, match))
+		// An IPv6 host captured from the target keeps its brackets, which JoinHostPort adds again.
+		if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+			address = address[1 : len(address)-1]
+		}
+		address = net.JoinHostPort(address, port)
+	}
+	return address, true
+}
+
+// Mappings is a ProxyProvider using the first mapping that matches a target.
+type Mappings []Mapping
+
+func (ms Mappings) Map(target string) (string, bool) {
+	for _, m := range ms {
+		if address, ok := m.Map(target); ok {
+			return address, true
+		}
+	}
+	return "", false
+}
+
+func (ms Mappings) ProxyProvide(ctx context.Context, target string) (proxy.Proxy, error) {
+	address, ok := ms.Map(target)
+	if !ok {
+		return nil, fmt.Errorf("no mapping for %v", target)
+	}
+	if socket, ok := strings.CutPrefix(address, "unix:"); ok {
+		return proxy.UnixSocket(socket), nil
+	}
+	return proxy.Direct("tcp", address), nil
+}
+
+// ParseMappings parses "pattern [->] address" lines.
+func ParseMappings(filename string, data []byte) (Mappings, error) {
+	sc := bufio.NewScanner(bytes.NewBuffer(data))
+	ret := make(Mappings, 0)
+	errs := make([]error, 0)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := sc.Text()
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[1] == "->" {
+			fields = []string{fields[0], fields[2]}
+		}
+		if len(fields) != 2 {
+			errs = append(errs, fmt.Errorf("%v:%v: invalid mapping %q", filename, lineno, line))
+			continue
+		}
+		m, err := NewMapping(fields[0], fields[1])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v:%v: %w", filename, lineno, err))
+			continue
+		}
+		ret = append(ret, m)
+	}
+	return ret, errors.Join(errs...)
+}
+
+// MappingFile is a ProxyProvider with mappings read from a file, reloaded when the file changes.
+// A file that fails to load leaves the previous mappings in use.
+type MappingFile struct {
+	filename string
+	mappings atomic.Pointer[Mappings]
+	reloads  atomic.Uint64
+}
+
+func NewMappingFile(ctx context.Context, filename string, pollInterval time.Duration) (*MappingFile, error) {
+	f := &MappingFile{filename: filename}
+	fswatch.Watch(ctx, filename, pollInterval, func() {
+		if err := f.Reload(); err != nil {
+			logrus.Errorf("Cannot reload mappings: %v", err)
+		}
+	})
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *MappingFile) Reload() error {
+	data, err := os.ReadFile(f.filename)
+	if err != nil {
+		return err
+	}
+	mappings, err := ParseMappings(f.filename, data)
+	if err != nil {
+		return err
+	}
+	f.mappings.Store(&mappings)
+	f.reloads.Add(1)
+	logrus.Infof("Loaded %v mappings from %v", len(mappings), f.filename)
+	return nil
+}
+
+// Reloads counts the successful loads, including the initial one.
+func (f *MappingFile) Reloads() uint64 {
+	return f.reloads.Load()
+}
+
+func (f *MappingFile) ProxyProvide(ctx context.Context, target string) (proxy.Proxy, error) {
+	return f.mappings.Load().ProxyProvide(ctx, target)
+}
@@ -0,0 +1,99 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pigeonligh/srp/pkg/proxy"
+)
+
+func TestMappingMap(t *testing.T) {
+	tests := []struct {
+		pattern, address string
+		target           string
+		want             string
+		ok               bool
+	}{
+		// fixed addresses
+		{"*.db.internal", "10.0.0.5", "pg.db.internal:5432", "10.0.0.5:5432", true},
+		{"*.db.internal", "10.0.0.5:6432", "pg.db.internal:5432", "10.0.0.5:6432", true},
+		{"*.db.internal:5432", "10.0.0.5:6432", "pg.db.internal:5432", "10.0.0.5:6432", true},
+		{"*.db.internal:5432", "10.0.0.5:6432", "pg.db.internal:3306", "", false},
+		{"*.db.internal", "10.0.0.5", "a.pg.db.internal:5432", "", false},
+		{"*.db.internal", "10.0.0.5", "pg.db.internal", "", false},
+
+		// IPv4
+		{"10.0.*.*", "$1-$2.hosts", "10.0.1.2:22", "1-2.hosts:22", true},
+		{"10.0.*.*:22", "192.168.$1.$2:2222", "10.0.1.2:22", "192.168.1.2:2222", true},
+
+		// IPv6
+		{"db", "::1", "db:5432", "[::1]:5432", true},
+		{"db", "[::1]", "db:5432", "[::1]:5432", true},
+		{"db", "[::1]:6432", "db:5432", "[::1]:6432", true},
+		{"**", "$1", "[::1]:5432", "[::1]:5432", true},
+		{"**", "$1", "[fd00::1:2]:443", "[fd00::1:2]:443", true},
+		{"**.v6", "[fd00::1]", "a.b.v6:443", "[fd00::1]:443", true},
+
+		// **
+		{"**", "$1", "10.1.2.3:80", "10.1.2.3:80", true},
+		{"**.example", "$1.internal", "a.b.example:8080", "a.b.internal:8080", true},
+		{"**.example", "$1.internal", "example:8080", "", false},
+		{"*.lan", "${1}x.local", "host.lan:22", "hostx.local:22", true},
+
+		// unix sockets
+		{"*.sock.internal", "unix:/run/$1.sock", "pg.sock.internal:5432", "unix:/run/pg.sock", true},
+		{"*.sock.internal:80", "unix:/run/$1.sock", "web.sock.internal:80", "unix:/run/web.sock", true},
+		{"**", "unix:/run/srp.sock", "[::1]:5432", "unix:/run/srp.sock", true},
+	}
+	for _, tt := range tests {
+		m, err := NewMapping(tt.pattern, tt.address)
+		if err != nil {
+			t.Fatalf("NewMapping(%q, %q): %v", tt.pattern, tt.address, err)
+		}
+		got, ok := m.Map(tt.target)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q -> %q: Map(%q) = %q, %v, want %q, %v", tt.pattern, tt.address, tt.target, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMappingsProxyProvide(t *testing.T) {
+	ms, err := ParseMappings("mappings", []byte(`
+# comment
+*.sock.internal -> unix:/run/$1.sock
+*.db.internal      10.0.0.5
+**                 [fd00::1]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, want := range map[string]proxy.Proxy{
+		"pg.sock.internal:5432": proxy.UnixSocket("/run/pg.sock"),
+		"pg.db.internal:5432":   proxy.Direct("tcp", "10.0.0.5:5432"),
+		"example.com:443":       proxy.Direct("tcp", "[fd00::1]:443"),
+	} {
+		got, err := ms.ProxyProvide(context.Background(), target)
+		if err != nil {
+			t.Errorf("ProxyProvide(%q): %v", target, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ProxyProvide(%q) = %+v, want %+v", target, got, want)
+		}
+	}
+
+	if _, err := ms[:2].ProxyProvide(context.Background(), "example.com:443"); err == nil {
+		t.Error("ProxyProvide without a matching mapping succeeded")
+	}
+}
+
+func TestParseMappingsErrors(t *testing.T) {
+	ms, err := ParseMappings("mappings", []byte("a.internal 10.0.0.1\nonly-one-field\na b c d\nb.internal -> 10.0.0.2\n"))
+	if err == nil {
+		t.Fatal("ParseMappings accepted invalid lines")
+	}
+	if len(ms) != 2 {
+		t.Errorf("ParseMappings kept %v valid mappings, want 2", len(ms))
+	}
+}
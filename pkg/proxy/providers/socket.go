@@ -32,11 +32,16 @@ func (p *socketProvider) ProxyProvide(ctx context.Context, target string) (proxy
 
 	ret := proxy.UnixSocket(socket)
 	if p.waitInterval > 0 {
-		ret = proxy.ProxyWithReadiness(ret, func(ctx context.Context) bool {
+		return proxy.ProxyWithReadiness(ret, func(ctx context.Context) bool {
 			return p.h.SocketAlive(socket)
-		}, p.waitInterval)
+		}, p.waitInterval), nil
 	}
-	return ret, nil
+	return proxy.ProxyFunc(func(ctx context.Context) (net.Conn, error) {
+		if !p.h.SocketAlive(socket) {
+			return nil, fmt.Errorf("target %v is not alive", target)
+		}
+		return ret.Dial(ctx)
+	}), nil
 }
 
 type SocketFile string
@@ -0,0 +1,235 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	"github.com/pigeonligh/srp/pkg/proxy"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type UpstreamConfig struct {
+	// ServerID identifies this server in the federation. It is sent along with every channel,
+	// so a channel coming back to this server is rejected instead of looping. It is required.
+	ServerID string
+
+	Network      string // default "tcp"
+	Address      string
+	ClientConfig *gossh.ClientConfig // user, credentials and host key check for the upstream
+	Dialer       nets.SSHDialer      // default nets.NetSSHDialer(nil)
+	DialTimeout  time.Duration       // for the connect and SSH handshake; default ClientConfig.Timeout, or 30s
+
+	KeepaliveInterval time.Duration // default 30s
+	RetryInterval     time.Duration // after a failed connect, fail fast for this long; default 5s
+	MaxHops           int           // default 4
+}
+
+type UpstreamStatus struct {
+	Address   string
+	Connected bool
+	Since     time.Time // of the last connect or disconnect
+	LastError error
+}
+
+// UpstreamProvider opens channels on another SRP server, over one SSH connection shared by all sessions.
+// The connection is made on first use and made again after it breaks.
+type UpstreamProvider struct {
+	config UpstreamConfig
+
+	client      *gossh.Client
+	dialing     chan struct{} // closed when the connect in progress ends
+	status      UpstreamStatus
+	lastAttempt time.Time
+	mu          sync.Mutex
+}
+
+func NewUpstreamProvider(config UpstreamConfig) (*UpstreamProvider, error) {
+	if config.ServerID == "" {
+		return nil, fmt.Errorf("upstream %v: server id is required for loop prevention", config.Address)
+	}
+	if strings.Contains(config.ServerID, ",") {
+		return nil, fmt.Errorf("upstream %v: invalid server id %q", config.Address, config.ServerID)
+	}
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.Dialer == nil {
+		config.Dialer = nets.NetSSHDialer(nil)
+	}
+	if config.DialTimeout <= 0 && config.ClientConfig != nil {
+		config.DialTimeout = config.ClientConfig.Timeout
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 30 * time.Second
+	}
+	if config.KeepaliveInterval <= 0 {
+		config.KeepaliveInterval = 30 * time.Second
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	if config.MaxHops <= 0 {
+		config.MaxHops = 4
+	}
+	return &UpstreamProvider{
+		config: config,
+		status: UpstreamStatus{Address: config.Address},
+	}, nil
+}
+
+func (p *UpstreamProvider) ProxyProvide(ctx context.Context, target string) (proxy.Proxy, error) {
+	host, portString, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %v", target)
+	}
+
+	hops := protocol.FederationHops(proxy.OriginatorFromContext(ctx))
+	if slices.Contains(hops, p.config.ServerID) {
+		return nil, fmt.Errorf("federation loop for %v through %v", target, hops)
+	}
+	if len(hops) >= p.config.MaxHops {
+		return nil, fmt.Errorf("too many federation hops for %v", target)
+	}
+	payload := gossh.Marshal(&protocol.DirectPayload{
+		Host:              host,
+		Port:              uint32(port),
+		OriginatorAddress: protocol.FederationOriginator(append(slices.Clone(hops), p.config.ServerID)),
+	})
+
+	return proxy.ProxyFunc(func(ctx context.Context) (net.Conn, error) {
+		client, err := p.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ch, reqs, err := client.OpenChannel("direct-tcpip", payload)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %v: %w", p.config.Address, err)
+		}
+		go gossh.DiscardRequests(reqs)
+		return nets.ChannelConn(ch, client.LocalAddr(), client.RemoteAddr()), nil
+	}), nil
+}
+
+func (p *UpstreamProvider) connect(ctx context.Context) (*gossh.Client, error) {
+	p.mu.Lock()
+	if p.client == nil && p.dialing == nil {
+		if p.status.LastError != nil && time.Since(p.lastAttempt) < p.config.RetryInterval {
+			err := p.status.LastError
+			p.mu.Unlock()
+			return nil, fmt.Errorf("upstream %v is unavailable: %w", p.config.Address, err)
+		}
+		p.dialing = make(chan struct{})
+		go p.dial(p.dialing)
+	}
+	client, dialing := p.client, p.dialing
+	p.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	// The dial is shared by every session waiting for it, so none of them can cancel it.
+	select {
+	case <-dialing:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	err := p.status.LastError
+	if err == nil {
+		err = fmt.Errorf("disconnected")
+	}
+	return nil, fmt.Errorf("upstream %v: %w", p.config.Address, err)
+}
+
+func (p *UpstreamProvider) dial(dialing chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.DialTimeout)
+	defer cancel()
+	// Dial without the lock, so Status does not wait for it.
+	client, err := p.config.Dialer.DialContext(ctx, p.config.Network, p.config.Address, p.config.ClientConfig)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing = nil
+	p.lastAttempt = time.Now()
+	close(dialing)
+	if err != nil {
+		logrus.Warnf("Cannot connect upstream %v: %v", p.config.Address, err)
+		p.status.LastError = err
+		return
+	}
+	logrus.Infof("Connected upstream %v", p.config.Address)
+	p.client = client
+	p.status = UpstreamStatus{Address: p.config.Address, Connected: true, Since: time.Now()}
+
+	go p.keepalive(client)
+	go func() {
+		err := client.Wait()
+		logrus.Warnf("Upstream %v disconnected: %v", p.config.Address, err)
+		p.mu.Lock()
+		if p.client == client {
+			p.client = nil
+			p.status = UpstreamStatus{Address: p.config.Address, Since: time.Now(), LastError: err}
+		}
+		p.mu.Unlock()
+	}()
+}
+
+func (p *UpstreamProvider) keepalive(client *gossh.Client) {
+	t := time.NewTicker(p.config.KeepaliveInterval)
+	defer t.Stop()
+	for range t.C {
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			result <- err
+		}()
+
+		var err error
+		select {
+		case err = <-result:
+		case <-time.After(p.config.KeepaliveInterval):
+			err = fmt.Errorf("keepalive timeout")
+		}
+		if err != nil {
+			// Wait returns after Close and marks the upstream disconnected.
+			_ = client.Close()
+			return
+		}
+	}
+}
+
+// Status reports the health of the upstream connection.
+func (p *UpstreamProvider) Status() UpstreamStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Close closes the upstream connection. It is made again on next use.
+func (p *UpstreamProvider) Close() error {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// startUpstream runs an SSH server that waits for delay before its handshake, or never speaks when stall is set.
+func startUpstream(t *testing.T, delay time.Duration, stall bool) (string, *atomic.Int32) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns sync.WaitGroup
+	t.Cleanup(func() {
+		_ = l.Close()
+		conns.Wait()
+	})
+
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				defer c.Close()
+				if stall {
+					_, _ = c.Read(make([]byte, 1))
+					_, _ = c.Read(make([]byte, 1<<10))
+					return
+				}
+				time.Sleep(delay)
+				sshConn, chans, reqs, err := gossh.NewServerConn(c, config)
+				if err != nil {
+					return
+				}
+				go gossh.DiscardRequests(reqs)
+				for ch := range chans {
+					_ = ch.Reject(gossh.Prohibited, "test")
+				}
+				_ = sshConn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+func newTestUpstream(t *testing.T, address string, clientTimeout time.Duration) *UpstreamProvider {
+	t.Helper()
+	p, err := NewUpstreamProvider(UpstreamConfig{
+		ServerID: "test",
+		Address:  address,
+		ClientConfig: &gossh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         clientTimeout,
+		},
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return p
+}
+
+func TestNewUpstreamProviderServerID(t *testing.T) {
+	for _, id := range []string{"", "a,b"} {
+		if _, err := NewUpstreamProvider(UpstreamConfig{ServerID: id, Address: "127.0.0.1:22"}); err == nil {
+			t.Errorf("server id %q is accepted", id)
+		}
+	}
+}
+
+func TestUpstreamStalledHandshake(t *testing.T) {
+	address, accepted := startUpstream(t, 0, true)
+	p := newTestUpstream(t, address, 200*time.Millisecond)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		start := time.Now()
+		if _, err := p.connect(context.Background()); err == nil {
+			t.Fatal("connect to a stalled upstream succeeded")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("connect to a stalled upstream took %v", elapsed)
+		}
+		if p.Status().LastError == nil {
+			t.Error("Status has no error after a failed connect")
+		}
+		// The upstream is tried again once the retry interval is over.
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("upstream dialed %v times, want 2", n)
+	}
+}
+
+func TestUpstreamDialOutlivesCaller(t *testing.T) {
+	address, accepted := startUpstream(t, 300*time.Millisecond, false)
+	p := newTestUpstream(t, address, 0)
+
+	// The session that starts the dial goes away before it completes.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("connect = %v, want the caller's deadline", err)
+	}
+
+	// Other sessions still get the connection.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := p.connect(ctx); err != nil {
+				t.Errorf("connect: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if status := p.Status(); !status.Connected || status.LastError != nil {
+		t.Errorf("Status = %+v, want connected", status)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("upstream dialed %v times, want 1", n)
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"net"
 	"time"
 
@@ -40,12 +41,57 @@ func (f funcProxy) Dial(ctx context.Context) (net.Conn, error) {
 	return f(ctx)
 }
 
+func ProxyFunc(dial func(ctx context.Context) (net.Conn, error)) Proxy {
+	return funcProxy(dial)
+}
+
+// Prechecker is a Proxy that can tell its dial would be refused without dialing, like an open circuit,
+// so the handler rejects a channel instead of accepting it. Proxies wrapping others forward it.
+type Prechecker interface {
+	Precheck() error
+}
+
+// Precheck returns the error a dial of p would be refused with, when p can tell without dialing.
+func Precheck(p Proxy) error {
+	if pc, ok := p.(Prechecker); ok {
+		return pc.Precheck()
+	}
+	return nil
+}
+
+// wrapperProxy dials with dial, and prechecks the proxies dial uses.
+type wrapperProxy struct {
+	dial    funcProxy
+	wrapped []Proxy
+}
+
+func wrapProxy(dial func(ctx context.Context) (net.Conn, error), wrapped ...Proxy) Proxy {
+	return wrapperProxy{dial: dial, wrapped: wrapped}
+}
+
+func (p wrapperProxy) Dial(ctx context.Context) (net.Conn, error) {
+	return p.dial(ctx)
+}
+
+// Precheck fails when every wrapped proxy would be refused.
+func (p wrapperProxy) Precheck() error {
+	errs := make([]error, 0, len(p.wrapped))
+	for _, w := range p.wrapped {
+		err := Precheck(w)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 func ProxyWithTimeout(p Proxy, timeout time.Duration) Proxy {
-	return funcProxy(func(ctx context.Context) (net.Conn, error) {
+	return wrapProxy(func(ctx context.Context) (net.Conn, error) {
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		return p.Dial(ctx)
-	})
+	}, p)
 }
 
 func ProxyWithReadiness(p Proxy, readiness func(context.Context) bool, interval time.Duration) Proxy {
@@ -71,10 +117,10 @@ func ProxyWithReadiness(p Proxy, readiness func(context.Context) bool, interval
 		return nil
 	}
 
-	return funcProxy(func(ctx context.Context) (net.Conn, error) {
+	return wrapProxy(func(ctx context.Context) (net.Conn, error) {
 		if err := wait(ctx); err != nil {
 			return nil, err
 		}
 		return p.Dial(ctx)
-	})
+	}, p)
 }
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type RetryPolicy struct {
+	Attempts   int           // dials in total, default 3
+	Backoff    time.Duration // wait before the first retry, doubled for each next one; default 100ms
+	MaxBackoff time.Duration // default 2s
+}
+
+// ProxyWithRetry dials p again after failures, until the attempts are used up or ctx is done.
+// An open circuit is not retried.
+func ProxyWithRetry(p Proxy, policy RetryPolicy) Proxy {
+	if policy.Attempts <= 0 {
+		policy.Attempts = 3
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 2 * time.Second
+	}
+
+	return wrapProxy(func(ctx context.Context) (net.Conn, error) {
+		backoff := policy.Backoff
+		var err error
+		for i := 0; i < policy.Attempts; i++ {
+			if i > 0 {
+				t := time.NewTimer(backoff)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return nil, errors.Join(err, ctx.Err())
+				case <-t.C:
+				}
+				backoff = min(backoff*2, policy.MaxBackoff)
+			}
+
+			var c net.Conn
+			c, err = p.Dial(ctx)
+			if err == nil {
+				return c, nil
+			}
+			if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+				break
+			}
+		}
+		return nil, err
+	}, p)
+}
+
+func ProxyProviderWithRetry(p ProxyProvider, policy RetryPolicy) ProxyProvider {
+	return ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		proxy, err := p.ProxyProvide(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return ProxyWithRetry(proxy, policy), nil
+	})
+}
+
+// ErrCircuitOpen rejects dials to a target that failed too often recently.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // dials go through
+	CircuitOpen                         // dials are rejected
+	CircuitHalfOpen                     // one trial dial decides whether to close again
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type circuit struct {
+	state       CircuitState
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+	trial       bool // a half-open trial is in flight
+}
+
+const DefaultCircuitMaxTargets = 4096
+
+// CircuitBreaker tracks dial failures per target. Only targets with failures take memory.
+// The zero value is ready to use.
+type CircuitBreaker struct {
+	FailureThreshold int           // consecutive failures opening the circuit, default 5
+	OpenDuration     time.Duration // how long to reject before a trial dial, default 30s
+	// MaxTargets bounds the circuits tracked at once, as clients choose the targets.
+	// Closed circuits are dropped first, then the ones failing least recently. Default DefaultCircuitMaxTargets.
+	MaxTargets int
+
+	Clock func() time.Time // default time.Now
+
+	circuits map[string]*circuit
+	mu       sync.Mutex
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock()
+	}
+	return time.Now()
+}
+
+// failedLocked returns the circuit of target to record a failure in, making room for it if needed.
+func (b *CircuitBreaker) failedLocked(target string) *circuit {
+	if c, ok := b.circuits[target]; ok {
+		return c
+	}
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+
+	maxTargets := b.MaxTargets
+	if maxTargets <= 0 {
+		maxTargets = DefaultCircuitMaxTargets
+	}
+	if len(b.circuits) >= maxTargets {
+		for t, c := range b.circuits {
+			if c.state == CircuitClosed {
+				delete(b.circuits, t)
+			}
+		}
+	}
+	for len(b.circuits) >= maxTargets {
+		var oldest string
+		var oldestFailure time.Time
+		for t, c := range b.circuits {
+			if oldestFailure.IsZero() || c.lastFailure.Before(oldestFailure) {
+				oldest, oldestFailure = t, c.lastFailure
+			}
+		}
+		delete(b.circuits, oldest)
+	}
+
+	c := &circuit{}
+	b.circuits[target] = c
+	return c
+}
+
+// Allow returns ErrCircuitOpen when a dial to target should not be tried now.
+// Otherwise the caller must Record the result of its dial.
+func (b *CircuitBreaker) Allow(target string) error {
+	return b.check(target, true)
+}
+
+// check returns ErrCircuitOpen when target is rejected. With take, it takes the half-open trial.
+func (b *CircuitBreaker) check(target string, take bool) error {
+	openDuration := b.OpenDuration
+	if openDuration <= 0 {
+		openDuration = 30 * time.Second
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[target]
+	if !ok {
+		return nil
+	}
+	switch c.state {
+	case CircuitOpen:
+		if b.now().Sub(c.openedAt) < openDuration {
+			return fmt.Errorf("%v: %w", target, ErrCircuitOpen)
+		}
+		if take {
+			c.state = CircuitHalfOpen
+			c.trial = true
+		}
+	case CircuitHalfOpen:
+		if c.trial {
+			return fmt.Errorf("%v: %w", target, ErrCircuitOpen)
+		}
+		c.trial = take
+	}
+	return nil
+}
+
+// Record reports the result of a dial allowed by Allow.
+func (b *CircuitBreaker) Record(target string, err error) {
+	threshold := b.FailureThreshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if c, ok := b.circuits[target]; ok {
+			if c.state != CircuitClosed {
+				logrus.Infof("Circuit for %v is closed", target)
+			}
+			delete(b.circuits, target)
+		}
+		return
+	}
+
+	now := b.now()
+	c := b.failedLocked(target)
+	c.trial = false
+	c.failures++
+	c.lastFailure = now
+	if c.state == CircuitHalfOpen || c.failures >= threshold {
+		if c.state != CircuitOpen {
+			logrus.Warnf("Circuit for %v is open after %v failures: %v", target, c.failures, err)
+		}
+		c.state = CircuitOpen
+		c.openedAt = now
+	}
+}
+
+func (b *CircuitBreaker) State(target string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[target]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// ProxyProviderWithCircuitBreaker fails fast with ErrCircuitOpen for targets whose dials keep failing,
+// so their channels are rejected instead of accepted and closed.
+func ProxyProviderWithCircuitBreaker(p ProxyProvider, b *CircuitBreaker) ProxyProvider {
+	return ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		if err := b.check(target, false); err != nil {
+			return nil, err
+		}
+		proxy, err := p.ProxyProvide(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return &breakerProxy{Proxy: proxy, breaker: b, target: target}, nil
+	})
+}
+
+type breakerProxy struct {
+	Proxy
+	breaker *CircuitBreaker
+	target  string
+}
+
+func (p *breakerProxy) Dial(ctx context.Context) (net.Conn, error) {
+	if err := p.breaker.Allow(p.target); err != nil {
+		return nil, err
+	}
+	c, err := p.Proxy.Dial(ctx)
+	p.breaker.Record(p.target, err)
+	return c, err
+}
+
+// Precheck lets the handler reject a channel before accepting it, also for a proxy cached in the session.
+func (p *breakerProxy) Precheck() error {
+	return p.breaker.check(p.target, false)
+}
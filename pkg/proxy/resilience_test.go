@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDial = errors.New("dial failed")
+
+func newTestBreaker(now *time.Time) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: 3,
+		OpenDuration:     time.Minute,
+		Clock: func() time.Time {
+			return *now
+		},
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newTestBreaker(&now)
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow("a:1"); err != nil {
+			t.Fatalf("Allow before the threshold: %v", err)
+		}
+		b.Record("a:1", errDial)
+	}
+	if state := b.State("a:1"); state != CircuitClosed {
+		t.Fatalf("state below the threshold = %v, want closed", state)
+	}
+
+	// A success starts the count over.
+	b.Record("a:1", nil)
+	b.Record("a:1", errDial)
+	b.Record("a:1", errDial)
+	if state := b.State("a:1"); state != CircuitClosed {
+		t.Fatalf("state after a success = %v, want closed", state)
+	}
+
+	b.Record("a:1", errDial)
+	if state := b.State("a:1"); state != CircuitOpen {
+		t.Fatalf("state at the threshold = %v, want open", state)
+	}
+	if err := b.Allow("a:1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+	if err := b.Allow("b:1"); err != nil {
+		t.Fatalf("Allow of another target = %v", err)
+	}
+}
+
+func openCircuit(b *CircuitBreaker, target string) {
+	for i := 0; i < 3; i++ {
+		b.Record(target, errDial)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	for _, trial := range []struct {
+		name  string
+		err   error
+		state CircuitState
+	}{
+		{"trial succeeds", nil, CircuitClosed},
+		{"trial fails", errDial, CircuitOpen},
+	} {
+		t.Run(trial.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			b := newTestBreaker(&now)
+			openCircuit(b, "a:1")
+
+			now = now.Add(59 * time.Second)
+			if err := b.Allow("a:1"); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("Allow before OpenDuration = %v, want ErrCircuitOpen", err)
+			}
+
+			now = now.Add(time.Second)
+			if err := b.check("a:1", false); err != nil {
+				t.Fatalf("check after OpenDuration = %v", err)
+			}
+			if err := b.Allow("a:1"); err != nil {
+				t.Fatalf("Allow of the trial = %v", err)
+			}
+			if state := b.State("a:1"); state != CircuitHalfOpen {
+				t.Fatalf("state during the trial = %v, want half-open", state)
+			}
+			// There is a single trial.
+			if err := b.Allow("a:1"); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second Allow during the trial = %v, want ErrCircuitOpen", err)
+			}
+			if err := b.check("a:1", false); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("check during the trial = %v, want ErrCircuitOpen", err)
+			}
+
+			b.Record("a:1", trial.err)
+			if state := b.State("a:1"); state != trial.state {
+				t.Fatalf("state after the trial = %v, want %v", state, trial.state)
+			}
+			if trial.err != nil {
+				// Open again for a whole OpenDuration.
+				now = now.Add(59 * time.Second)
+				if err := b.Allow("a:1"); !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("Allow after a failed trial = %v, want ErrCircuitOpen", err)
+				}
+			} else if err := b.Allow("a:1"); err != nil {
+				t.Fatalf("Allow after a successful trial = %v", err)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerBounded(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newTestBreaker(&now)
+	b.MaxTargets = 4
+
+	// Looking targets up does not track them.
+	provider := ProxyProviderWithCircuitBreaker(ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		return nil, fmt.Errorf("no route to %v", target)
+	}), b)
+	for i := 0; i < 100; i++ {
+		_, _ = provider.ProxyProvide(context.Background(), fmt.Sprintf("host%v:1", i))
+		_ = b.Allow(fmt.Sprintf("other%v:1", i))
+	}
+	if n := len(b.circuits); n != 0 {
+		t.Fatalf("%v circuits tracked without failures", n)
+	}
+
+	openCircuit(b, "open:1")
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		b.Record(fmt.Sprintf("host%v:1", i), errDial)
+	}
+	if n := len(b.circuits); n > 4 {
+		t.Fatalf("%v circuits tracked, want at most 4", n)
+	}
+	// Open circuits outlive closed ones.
+	if state := b.State("open:1"); state != CircuitOpen {
+		t.Errorf("open circuit is dropped for closed ones")
+	}
+}
+
+// countingProxy fails with err for the first failures dials.
+type countingProxy struct {
+	dials    atomic.Int32
+	failures int32
+	err      error
+}
+
+func (p *countingProxy) Dial(ctx context.Context) (net.Conn, error) {
+	if p.dials.Add(1) <= p.failures {
+		return nil, p.err
+	}
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func TestProxyWithRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	p := &countingProxy{failures: 2, err: errDial}
+	c, err := ProxyWithRetry(p, policy).Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial = %v, want success on the third attempt", err)
+	}
+	_ = c.Close()
+
+	p = &countingProxy{failures: 5, err: errDial}
+	if _, err := ProxyWithRetry(p, policy).Dial(context.Background()); !errors.Is(err, errDial) {
+		t.Fatalf("Dial = %v, want the last error", err)
+	}
+	if n := p.dials.Load(); n != 3 {
+		t.Errorf("%v dials, want 3", n)
+	}
+}
+
+func TestProxyWithRetryStops(t *testing.T) {
+	t.Run("circuit open", func(t *testing.T) {
+		p := &countingProxy{failures: 5, err: fmt.Errorf("a:1: %w", ErrCircuitOpen)}
+		_, err := ProxyWithRetry(p, RetryPolicy{Attempts: 5, Backoff: time.Second}).Dial(context.Background())
+		if !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Dial = %v, want ErrCircuitOpen", err)
+		}
+		if n := p.dials.Load(); n != 1 {
+			t.Errorf("%v dials, want 1", n)
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		p := &countingProxy{failures: 5, err: errDial}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := ProxyWithRetry(p, RetryPolicy{Attempts: 5, Backoff: time.Minute}).Dial(ctx)
+		if !errors.Is(err, context.Canceled) || !errors.Is(err, errDial) {
+			t.Fatalf("Dial = %v, want the dial error and context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Dial took %v after cancel", elapsed)
+		}
+		if n := p.dials.Load(); n != 1 {
+			t.Errorf("%v dials, want 1", n)
+		}
+	})
+}
+
+func TestPrecheckThroughWrappers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newTestBreaker(&now)
+	breaker, err := ProxyProviderWithCircuitBreaker(ProxyProviderFunc(func(ctx context.Context, target string) (Proxy, error) {
+		return &countingProxy{}, nil
+	}), b).ProxyProvide(context.Background(), "b:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The circuit opens after the proxy is provided, like for a proxy cached in the session.
+	openCircuit(b, "b:1")
+
+	for name, p := range map[string]Proxy{
+		"breaker":   breaker,
+		"retry":     ProxyWithRetry(breaker, RetryPolicy{}),
+		"timeout":   ProxyWithTimeout(breaker, time.Second),
+		"readiness": ProxyWithReadiness(breaker, func(context.Context) bool { return true }, time.Second),
+		"fallback":  FallbackProxy(breaker, ProxyWithRetry(breaker, RetryPolicy{})),
+		"nested":    ProxyWithTimeout(ProxyWithRetry(breaker, RetryPolicy{}), time.Second),
+	} {
+		if err := Precheck(p); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("%v: Precheck = %v, want ErrCircuitOpen", name, err)
+		}
+	}
+
+	// A fallback that may still dial something else is not rejected.
+	if err := Precheck(FallbackProxy(breaker, &countingProxy{})); err != nil {
+		t.Errorf("Precheck of a fallback with a usable proxy = %v", err)
+	}
+	if err := Precheck(&countingProxy{}); err != nil {
+		t.Errorf("Precheck of a plain proxy = %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// PacketDialer opens UDP flows as streams of length-prefixed packets, see nets.WritePacket.
+type PacketDialer interface {
+	DialPacket(ctx context.Context, target string) (io.ReadWriteCloser, error)
+}
+
+type PacketDialerFunc func(ctx context.Context, target string) (io.ReadWriteCloser, error)
+
+func (f PacketDialerFunc) DialPacket(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	return f(ctx, target)
+}
+
+func (h *handler) HandleUDP(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	logrus.Infof("Handle %v for user %v in %v", newChan.ChannelType(), ctx.User(), ctx.SessionID())
+
+	reject := func(reason gossh.RejectionReason, err error) {
+		logrus.Errorf("Cannot create UDP proxy for %v: %v", ctx.SessionID(), err)
+		if rejectErr := newChan.Reject(reason, err.Error()); rejectErr != nil {
+			logrus.Errorf("Cannot reject channel for %v: %v", ctx.SessionID(), rejectErr)
+		}
+	}
+
+	var payload protocol.DirectPayload
+	if err := gossh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+		reject(gossh.ConnectionFailed, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+	if authed, _ := ctx.Value(protocol.ContextKeyProxyAuthed).(bool); !authed {
+		reject(gossh.Prohibited, fmt.Errorf("unauthenticated for proxy"))
+		return
+	}
+	if h.packetDialer == nil {
+		reject(gossh.Prohibited, fmt.Errorf("UDP forwarding is disabled"))
+		return
+	}
+
+	target := net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port))
+	var notAfter time.Time
+	if h.authorizer != nil {
+		var err error
+		notAfter, err = h.authorize(ctx, target, net.JoinHostPort(payload.OriginatorAddress, fmt.Sprint(payload.OriginatorPort)))
+		if err != nil {
+			reject(gossh.Prohibited, err)
+			return
+		}
+	}
+
+	stream, err := h.packetDialer.DialPacket(ctx, target)
+	if err != nil {
+		reject(gossh.ConnectionFailed, err)
+		return
+	}
+	defer stream.Close()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		logrus.Errorf("Cannot accept channel for %v: %v", ctx.SessionID(), err)
+		return
+	}
+	defer ch.Close()
+	go gossh.DiscardRequests(reqs)
+
+	if !notAfter.IsZero() {
+		t := time.AfterFunc(time.Until(notAfter), func() {
+			logrus.Infof("Access to UDP %v for session %v expired.", target, ctx.SessionID())
+			stream.Close()
+			ch.Close()
+		})
+		defer t.Stop()
+	}
+	// Both sides carry length-prefixed packets, so bytes are copied as they are.
+	if err := nets.HandleConnections(stream, ch); err != nil {
+		logrus.Errorf("Cannot handle UDP proxy for %v: %v", ctx.SessionID(), err)
+		return
+	}
+	logrus.Infof("UDP proxy done for session %v.", ctx.SessionID())
+}
@@ -3,6 +3,9 @@ package reverseproxy
 type EventHandler struct {
 	OnAdd    func(host string, port string)
 	OnRemove func(host string, port string)
+
+	// OnHealthChange is called when a health check finds a target healthy or unhealthy.
+	OnHealthChange func(host string, port string, healthy bool)
 }
 
 type EventHandlers []EventHandler
@@ -22,3 +25,11 @@ func (hs EventHandlers) OnRemove(host string, port string) {
 		}
 	}
 }
+
+func (hs EventHandlers) OnHealthChange(host string, port string, healthy bool) {
+	for _, h := range hs {
+		if h.OnHealthChange != nil {
+			go h.OnHealthChange(host, port, healthy)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package reverseproxy
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/charmbracelet/ssh"
 	"github.com/pigeonligh/srp/pkg/auth"
@@ -29,43 +31,68 @@ type Handler interface {
 	ConvertBindAddressToSocket(bindAddress string) (string, bool)
 
 	SocketList() []string
+	Targets() []TargetStatus
+	DialPacket(ctx context.Context, target string) (io.ReadWriteCloser, error)
 	AddEventHandler(EventHandler)
 }
 
+type forward struct {
+	ln    net.Listener
+	host  string
+	port  string
+	user  string
+	conns closers
+
+	health    Health
+	lastCheck time.Time
+	lastError error
+}
+
 type handler struct {
 	authenticator auth.Authenticator
 	authorizer    auth.Authorizer
 	unixDirectory string
+	healthCheck   func(host, port string) *HealthCheck
 
-	forwards map[string]net.Listener // uid => listener
+	forwards    map[string]*forward    // uid => forward
+	udpForwards map[string]*udpForward // host:port => forward
 	sync.Mutex
 
 	eventHandlers EventHandlers
 }
 
 func New(authenticator auth.Authenticator, authorizer auth.Authorizer, unixDirectory string) (Handler, error) {
-	if unixDirectory == "" {
+	return NewWithOptions(
+		WithAuthenticator(authenticator),
+		WithAuthorizer(authorizer),
+		WithUnixDirectory(unixDirectory),
+	)
+}
+
+func NewWithOptions(options ...Option) (Handler, error) {
+	h := &handler{
+		forwards:    make(map[string]*forward),
+		udpForwards: make(map[string]*udpForward),
+
+		eventHandlers: make(EventHandlers, 0),
+	}
+	for _, opt := range options {
+		opt(h)
+	}
+
+	if h.unixDirectory == "" {
 		dir, err := os.MkdirTemp("", "srp")
 		if err != nil {
 			return nil, err
 		}
-		unixDirectory = dir
+		h.unixDirectory = dir
 	} else {
-		err := os.MkdirAll(unixDirectory, os.ModePerm)
+		err := os.MkdirAll(h.unixDirectory, os.ModePerm)
 		if err != nil {
 			return nil, err
 		}
 	}
-
-	return &handler{
-		authenticator: authenticator,
-		authorizer:    authorizer,
-		unixDirectory: unixDirectory,
-
-		forwards: make(map[string]net.Listener),
-
-		eventHandlers: make(EventHandlers, 0),
-	}, nil
+	return h, nil
 }
 
 func (h *handler) PasswordHandler() ssh.PasswordHandler {
@@ -74,12 +101,14 @@ func (h *handler) PasswordHandler() ssh.PasswordHandler {
 		if h.authenticator == nil {
 			ret = true
 		} else {
-			ret = h.authenticator.Authenticate(ctx, auth.AuthenticateRequest{
-				User:     ctx.User(),
-				Password: password,
+			ret = h.authenticator.Authenticate(auth.WithKeyOptionsScope(ctx, string(protocol.RoleReverseProxy)), auth.AuthenticateRequest{
+				User:       ctx.User(),
+				Password:   password,
+				RemoteAddr: ctx.RemoteAddr(),
 			})
 		}
 
+		// pkg/server replaces this with the result of the credential that finished authentication.
 		ctx.SetValue(protocol.ContextKeyReverseProxyAuthed, ret)
 		return ret
 	}
@@ -91,12 +120,14 @@ func (h *handler) PublicKeyHandler() ssh.PublicKeyHandler {
 		if h.authenticator == nil {
 			ret = true
 		} else {
-			ret = h.authenticator.Authenticate(ctx, auth.AuthenticateRequest{
-				User:      ctx.User(),
-				PublicKey: key,
+			ret = h.authenticator.Authenticate(auth.WithKeyOptionsScope(ctx, string(protocol.RoleReverseProxy)), auth.AuthenticateRequest{
+				User:       ctx.User(),
+				PublicKey:  key,
+				RemoteAddr: ctx.RemoteAddr(),
 			})
 		}
 
+		// pkg/server replaces this with the result of the credential that finished authentication.
 		ctx.SetValue(protocol.ContextKeyReverseProxyAuthed, ret)
 		return ret
 	}
@@ -127,11 +158,12 @@ func (h *handler) ConvertBindAddressToSocket(bindAddress string) (string, bool)
 	return "", false
 }
 
+// SocketAlive reports whether a client publishes socket, and it did not fail its health check.
 func (h *handler) SocketAlive(socket string) bool {
 	h.Lock()
-	_, ok := h.forwards[socket]
-	h.Unlock()
-	return ok
+	defer h.Unlock()
+	f, ok := h.forwards[socket]
+	return ok && f.health != HealthUnhealthy
 }
 
 func (h *handler) SocketList() []string {
@@ -173,14 +205,16 @@ func (h *handler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.
 			return false, []byte{}
 		}
 
+		var notAfter time.Time
 		if h.authorizer != nil {
-			if !h.authorizer.Authorize(ctx, auth.AuthorizeRequest{
-				User:   ctx.User(),
-				Target: net.JoinHostPort(host, port),
-			}) {
+			authCtx, expiry := auth.WithExpiry(auth.WithKeyOptionsScope(ctx, string(protocol.RoleReverseProxy)))
+			allowed := h.authorizer.Authorize(authCtx, auth.NewAuthorizeRequest(ctx, net.JoinHostPort(host, port), auth.ActionPublish))
+			auth.Decide(authCtx, allowed)
+			if !allowed {
 				logrus.Errorf("User %v request to proxy %v, but it's not allowed.", ctx.User(), reqPayload.BindUnixSocket)
 				return false, []byte{}
 			}
+			notAfter = expiry()
 		}
 
 		socket, _ := h.ConvertHostPortToSocket(host, port)
@@ -189,22 +223,37 @@ func (h *handler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.
 			logrus.Errorf("Failed to listen UnixSocket %v: %v", socket, err)
 			return false, []byte{}
 		}
+		f := &forward{ln: ln, host: host, port: port, user: ctx.User()}
 		h.Lock()
-		h.forwards[socket] = ln
+		h.forwards[socket] = f
 		h.eventHandlers.OnAdd(host, port)
 		h.Unlock()
 
 		go func() {
-			<-ctx.Done()
-			h.Lock()
-			ln, ok := h.forwards[socket]
-			h.Unlock()
-			if ok {
-				ln.Close()
+			var expired <-chan time.Time
+			if !notAfter.IsZero() {
+				t := time.NewTimer(time.Until(notAfter))
+				defer t.Stop()
+				expired = t.C
+			}
+			select {
+			case <-ctx.Done():
+			case <-expired:
+				logrus.Infof("Access to %v for user %v expired.", reqPayload.BindUnixSocket, ctx.User())
+				f.conns.closeAll()
 			}
+			f.ln.Close()
 		}()
 
+		done := make(chan struct{})
+		if h.healthCheck != nil {
+			if hc := h.healthCheck(host, port); hc != nil {
+				go h.runHealthCheck(done, f, conn, reqPayload.BindUnixSocket, *hc)
+			}
+		}
+
 		go func() {
+			defer close(done)
 			for {
 				c, err := ln.Accept()
 				if err != nil {
@@ -212,7 +261,7 @@ func (h *handler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.
 					break
 				}
 
-				go handleConnection(c, conn, reqPayload.BindUnixSocket)
+				go handleConnection(c, conn, reqPayload.BindUnixSocket, &f.conns)
 			}
 			h.Lock()
 			delete(h.forwards, socket)
@@ -239,20 +288,27 @@ func (h *handler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.
 		}
 
 		h.Lock()
-		ln, ok := h.forwards[socket]
+		f, ok := h.forwards[socket]
 		h.Unlock()
 		if ok {
-			ln.Close()
+			f.ln.Close()
 			logrus.Infof("Forward request in %v is canneled", socket)
 		}
 		return true, nil
+
+	case protocol.UDPForwardRequestType, protocol.UDPCancelRequestType:
+		return h.handleUDPRequest(ctx, conn, req)
 	}
 
 	logrus.Infof("Unknown request %v from user %v", req.Type, ctx.User())
 	return false, []byte{}
 }
 
-func handleConnection(c net.Conn, conn *gossh.ServerConn, target string) {
+func handleConnection(c net.Conn, conn *gossh.ServerConn, target string, conns *closers) {
+	if !conns.add(c) {
+		c.Close()
+		return
+	}
 	payload := gossh.Marshal(&protocol.RemoteForwardChannelData{
 		SocketPath: target,
 		Reserved:   "",
@@ -260,18 +316,66 @@ func handleConnection(c net.Conn, conn *gossh.ServerConn, target string) {
 	ch, reqs, err := conn.OpenChannel(protocol.ForwardedRequestType, payload)
 	if err != nil {
 		logrus.Errorf("Failed to open channel for %v: %v", target, err)
+		conns.remove(c)
 		c.Close()
 		return
 	}
 	go gossh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		defer ch.Close()
 		defer c.Close()
 		_, _ = io.Copy(ch, c)
 	}()
 	go func() {
+		defer wg.Done()
 		defer ch.Close()
 		defer c.Close()
 		_, _ = io.Copy(c, ch)
 	}()
+	go func() {
+		wg.Wait()
+		conns.remove(c)
+	}()
+}
+
+// closers tracks the connections of a forward, so they can be cut when its access expires.
+type closers struct {
+	m      map[io.Closer]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// add reports false once closeAll was called, and the caller has to close c itself.
+func (cs *closers) add(c io.Closer) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return false
+	}
+	if cs.m == nil {
+		cs.m = make(map[io.Closer]struct{})
+	}
+	cs.m[c] = struct{}{}
+	return true
+}
+
+func (cs *closers) remove(c io.Closer) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.m, c)
+}
+
+func (cs *closers) closeAll() {
+	cs.mu.Lock()
+	m := cs.m
+	cs.m = nil
+	cs.closed = true
+	cs.mu.Unlock()
+	for c := range m {
+		_ = c.Close()
+	}
 }
@@ -0,0 +1,208 @@
+package reverseproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pigeonligh/srp/pkg/nets"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type Health int
+
+const (
+	HealthUnknown Health = iota // not checked yet, or checks are disabled; still routed
+	HealthHealthy
+	HealthUnhealthy
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthHealthy:
+		return "healthy"
+	case HealthUnhealthy:
+		return "unhealthy"
+	}
+	return "unknown"
+}
+
+type HealthCheck struct {
+	// HTTPPath sends GET requests to the path and expects a 2xx or 3xx status.
+	// When empty, opening a channel to the client's local service is enough.
+	HTTPPath string
+
+	Interval           time.Duration // default 10s
+	Timeout            time.Duration // default 5s
+	HealthyThreshold   int           // consecutive successes to become healthy, default 1
+	UnhealthyThreshold int           // consecutive failures to become unhealthy, default 3
+}
+
+type TargetStatus struct {
+	Network string // "tcp" or "udp"
+	Host    string
+	Port    string
+	Socket  string // empty for udp
+	User    string
+
+	Health    Health
+	LastCheck time.Time
+	LastError error
+}
+
+func (h *handler) Targets() []TargetStatus {
+	h.Lock()
+	defer h.Unlock()
+
+	ret := make([]TargetStatus, 0, len(h.forwards))
+	for socket, f := range h.forwards {
+		ret = append(ret, TargetStatus{
+			Network:   "tcp",
+			Host:      f.host,
+			Port:      f.port,
+			Socket:    socket,
+			User:      f.user,
+			Health:    f.health,
+			LastCheck: f.lastCheck,
+			LastError: f.lastError,
+		})
+	}
+	for _, f := range h.udpForwards {
+		ret = append(ret, TargetStatus{
+			Network: "udp",
+			Host:    f.host,
+			Port:    f.port,
+			User:    f.user,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Network != ret[j].Network {
+			return ret[i].Network < ret[j].Network
+		}
+		return net.JoinHostPort(ret[i].Host, ret[i].Port) < net.JoinHostPort(ret[j].Host, ret[j].Port)
+	})
+	return ret
+}
+
+// runHealthCheck checks f until done is closed.
+func (h *handler) runHealthCheck(done <-chan struct{}, f *forward, conn *gossh.ServerConn, target string, hc HealthCheck) {
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 5 * time.Second
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 1
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 3
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	successes, failures := 0, 0
+	t := time.NewTicker(hc.Interval)
+	defer t.Stop()
+	for {
+		err := probe(ctx, conn, target, f.host, f.port, hc)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			successes, failures = successes+1, 0
+		} else {
+			successes, failures = 0, failures+1
+		}
+
+		h.Lock()
+		f.lastCheck = time.Now()
+		f.lastError = err
+		old := f.health
+		if successes >= hc.HealthyThreshold {
+			f.health = HealthHealthy
+		} else if failures >= hc.UnhealthyThreshold {
+			f.health = HealthUnhealthy
+		}
+		if f.health != old {
+			logrus.Infof("Target %v is %v: %v", target, f.health, err)
+			h.eventHandlers.OnHealthChange(f.host, f.port, f.health == HealthHealthy)
+		}
+		h.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func probe(ctx context.Context, conn *gossh.ServerConn, target, host, port string, hc HealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		type result struct {
+			ch  gossh.Channel
+			err error
+		}
+		ret := make(chan result, 1)
+		go func() {
+			payload := gossh.Marshal(&protocol.RemoteForwardChannelData{SocketPath: target})
+			ch, reqs, err := conn.OpenChannel(protocol.ForwardedRequestType, payload)
+			if err == nil {
+				go gossh.DiscardRequests(reqs)
+			}
+			ret <- result{ch, err}
+		}()
+		select {
+		case r := <-ret:
+			if r.err != nil {
+				return nil, r.err
+			}
+			return nets.ChannelConn(r.ch, conn.LocalAddr(), conn.RemoteAddr()), nil
+		case <-ctx.Done():
+			go func() {
+				if r := <-ret; r.err == nil {
+					r.ch.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+
+	if hc.HTTPPath == "" {
+		c, err := dial(ctx, "", "")
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dial, DisableKeepAlives: true},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, port)+hc.HTTPPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package reverseproxy
+
+import "github.com/pigeonligh/srp/pkg/auth"
+
+type Option func(*handler)
+
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(h *handler) {
+		h.authenticator = authenticator
+	}
+}
+
+func WithAuthorizer(authorizer auth.Authorizer) Option {
+	return func(h *handler) {
+		h.authorizer = authorizer
+	}
+}
+
+func WithUnixDirectory(unixDirectory string) Option {
+	return func(h *handler) {
+		h.unixDirectory = unixDirectory
+	}
+}
+
+// WithHealthCheck sets how each published target is checked. It may return nil to skip a target.
+func WithHealthCheck(healthCheck func(host, port string) *HealthCheck) Option {
+	return func(h *handler) {
+		h.healthCheck = healthCheck
+	}
+}
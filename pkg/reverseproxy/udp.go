@@ -0,0 +1,146 @@
+package reverseproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/pigeonligh/srp/pkg/auth"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type udpForward struct {
+	conn *gossh.ServerConn
+	host string
+	port string
+	user string
+
+	cancel context.CancelFunc
+	flows  closers
+}
+
+func (h *handler) handleUDPRequest(ctx ssh.Context, conn *gossh.ServerConn, req *gossh.Request) (bool, []byte) {
+	switch req.Type {
+	case protocol.UDPForwardRequestType:
+		logrus.Infof("Handle UDP reverse proxy request for user %v", ctx.User())
+
+		var reqPayload protocol.UDPForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			logrus.Errorf("Failed to parse payload for %v request: %v", req.Type, err)
+			return false, []byte{}
+		}
+		host, port, ok := h.ConvertBindAddressToHostPort(reqPayload.Target)
+		if !ok {
+			logrus.Errorf("User %v request to proxy invalid UDP target %v.", ctx.User(), reqPayload.Target)
+			return false, []byte{}
+		}
+		target := net.JoinHostPort(host, port)
+
+		var notAfter time.Time
+		if h.authorizer != nil {
+			authCtx, expiry := auth.WithExpiry(auth.WithKeyOptionsScope(ctx, string(protocol.RoleReverseProxy)))
+			allowed := h.authorizer.Authorize(authCtx, auth.NewAuthorizeRequest(ctx, target, auth.ActionPublish))
+			auth.Decide(authCtx, allowed)
+			if !allowed {
+				logrus.Errorf("User %v request to proxy UDP %v, but it's not allowed.", ctx.User(), reqPayload.Target)
+				return false, []byte{}
+			}
+			notAfter = expiry()
+		}
+
+		h.Lock()
+		if _, ok := h.udpForwards[target]; ok {
+			h.Unlock()
+			logrus.Errorf("UDP target %v is already published.", target)
+			return false, []byte{}
+		}
+		var fctx context.Context
+		var cancel context.CancelFunc
+		if notAfter.IsZero() {
+			fctx, cancel = context.WithCancel(ctx)
+		} else {
+			fctx, cancel = context.WithDeadline(ctx, notAfter)
+		}
+		f := &udpForward{conn: conn, host: host, port: port, user: ctx.User(), cancel: cancel}
+		h.udpForwards[target] = f
+		h.eventHandlers.OnAdd(host, port)
+		h.Unlock()
+
+		go func() {
+			<-fctx.Done()
+			if fctx.Err() == context.DeadlineExceeded {
+				logrus.Infof("Access to UDP %v for user %v expired.", target, f.user)
+				f.flows.closeAll()
+			}
+			h.Lock()
+			if h.udpForwards[target] == f {
+				delete(h.udpForwards, target)
+				h.eventHandlers.OnRemove(host, port)
+			}
+			h.Unlock()
+		}()
+
+		logrus.Infof("UDP forward request for %v is ready", target)
+		return true, nil
+
+	case protocol.UDPCancelRequestType:
+		var reqPayload protocol.UDPForwardCancelRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			logrus.Errorf("Failed to parse payload for %v request: %v", req.Type, err)
+			return false, []byte{}
+		}
+		host, port, ok := h.ConvertBindAddressToHostPort(reqPayload.Target)
+		if !ok {
+			return false, []byte{}
+		}
+
+		h.Lock()
+		f, ok := h.udpForwards[net.JoinHostPort(host, port)]
+		h.Unlock()
+		// Only the publisher may cancel.
+		if !ok || f.conn != conn {
+			return false, []byte{}
+		}
+		f.cancel()
+		logrus.Infof("UDP forward request for %v is canceled", reqPayload.Target)
+		return true, nil
+	}
+	return false, []byte{}
+}
+
+// DialPacket opens a UDP flow to the client publishing target, as a stream of length-prefixed packets.
+func (h *handler) DialPacket(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	h.Lock()
+	f, ok := h.udpForwards[target]
+	h.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("UDP target %v is not alive", target)
+	}
+
+	data := protocol.ForwardedUDPChannelData{Target: fmt.Sprintf("/%v/%v", f.host, f.port)}
+	ch, reqs, err := f.conn.OpenChannel(protocol.ForwardedUDPChannelType, gossh.Marshal(&data))
+	if err != nil {
+		return nil, fmt.Errorf("open UDP channel for %v: %w", target, err)
+	}
+	go gossh.DiscardRequests(reqs)
+	if !f.flows.add(ch) {
+		ch.Close()
+		return nil, fmt.Errorf("UDP target %v is not alive", target)
+	}
+	return &udpFlow{Channel: ch, flows: &f.flows}, nil
+}
+
+type udpFlow struct {
+	gossh.Channel
+	flows *closers
+}
+
+func (f *udpFlow) Close() error {
+	f.flows.remove(f.Channel)
+	return f.Channel.Close()
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Auth results are kept in the permissions of each credential, so gossh reports the
+// roles of the credential that really finished authentication, not of the last attempt.
+const (
+	permissionRoles     = "srp-roles"
+	permissionPublicKey = "srp-publickey"
+)
+
+type publicKeyContextKey struct {
+	Fingerprint string
+}
+
+func applyConnMetadata(ctx ssh.Context, conn gossh.ConnMetadata) {
+	if ctx.Value(ssh.ContextKeySessionID) != nil {
+		return
+	}
+	ctx.SetValue(ssh.ContextKeySessionID, hex.EncodeToString(conn.SessionID()))
+	ctx.SetValue(ssh.ContextKeyClientVersion, string(conn.ClientVersion()))
+	ctx.SetValue(ssh.ContextKeyServerVersion, string(conn.ServerVersion()))
+	ctx.SetValue(ssh.ContextKeyUser, conn.User())
+	ctx.SetValue(ssh.ContextKeyLocalAddr, conn.LocalAddr())
+	ctx.SetValue(ssh.ContextKeyRemoteAddr, conn.RemoteAddr())
+}
+
+func rolesPermissions(roles []protocol.Role, extensions map[string]string) (*gossh.Permissions, error) {
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("permission denied")
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	if extensions == nil {
+		extensions = make(map[string]string)
+	}
+	extensions[permissionRoles] = strings.Join(names, ",")
+	return &gossh.Permissions{Extensions: extensions}, nil
+}
+
+func (s *server) passwordRoles(ctx ssh.Context, password string) []protocol.Role {
+	roles := make([]protocol.Role, 0)
+	if s.rp != nil && s.rp.PasswordHandler()(ctx, password) {
+		roles = append(roles, protocol.RoleReverseProxy)
+	}
+	if s.p != nil && s.p.PasswordHandler()(ctx, password) {
+		roles = append(roles, protocol.RoleProxy)
+	}
+	return roles
+}
+
+func (s *server) publicKeyRoles(ctx ssh.Context, key ssh.PublicKey) []protocol.Role {
+	roles := make([]protocol.Role, 0)
+	if s.rp != nil && s.rp.PublicKeyHandler()(ctx, key) {
+		roles = append(roles, protocol.RoleReverseProxy)
+	}
+	if s.p != nil && s.p.PublicKeyHandler()(ctx, key) {
+		roles = append(roles, protocol.RoleProxy)
+	}
+	return roles
+}
+
+func (s *server) authOption(srv *ssh.Server) error {
+	prev := srv.ServerConfigCallback
+	srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+		config := &gossh.ServerConfig{}
+		if prev != nil {
+			config = prev(ctx)
+		}
+
+		// Without any handler there is nothing to protect, but clients still have to try a method.
+		if s.rp == nil && s.p == nil {
+			config.NoClientAuthCallback = func(gossh.ConnMetadata) (*gossh.Permissions, error) {
+				return nil, fmt.Errorf("authentication required")
+			}
+			config.PasswordCallback = func(conn gossh.ConnMetadata, _ []byte) (*gossh.Permissions, error) {
+				applyConnMetadata(ctx, conn)
+				return &gossh.Permissions{}, nil
+			}
+			config.PublicKeyCallback = func(conn gossh.ConnMetadata, _ gossh.PublicKey) (*gossh.Permissions, error) {
+				applyConnMetadata(ctx, conn)
+				return &gossh.Permissions{}, nil
+			}
+			return config
+		}
+
+		config.NoClientAuthCallback = func(gossh.ConnMetadata) (*gossh.Permissions, error) {
+			return nil, fmt.Errorf("authentication required")
+		}
+		config.PasswordCallback = func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+			applyConnMetadata(ctx, conn)
+			if err := s.checkLockout(ctx); err != nil {
+				return nil, err
+			}
+			return s.recordLockout(ctx)(s.secondFactor(ctx)(rolesPermissions(s.passwordRoles(ctx, string(password)), nil)))
+		}
+		config.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			applyConnMetadata(ctx, conn)
+			if err := s.checkAddrLockout(ctx); err != nil {
+				return nil, err
+			}
+			fingerprint := gossh.FingerprintSHA256(key)
+			ctx.SetValue(publicKeyContextKey{fingerprint}, key)
+			return s.secondFactor(ctx)(rolesPermissions(s.publicKeyRoles(ctx, key), map[string]string{
+				permissionPublicKey: fingerprint,
+			}))
+		}
+		return config
+	}
+	return nil
+}
+
+func (s *server) checkLockout(ctx ssh.Context) error {
+	if s.lockout == nil || !s.lockout.Banned(ctx.RemoteAddr(), ctx.User()) {
+		return nil
+	}
+	logrus.Infof("Refuse authentication of user %v from %v: locked out", ctx.User(), ctx.RemoteAddr())
+	return fmt.Errorf("locked out")
+}
+
+// checkAddrLockout ignores user bans, as failed passwords for a user must not lock out its keys.
+func (s *server) checkAddrLockout(ctx ssh.Context) error {
+	if s.lockout == nil || !s.lockout.BannedAddr(ctx.RemoteAddr()) {
+		return nil
+	}
+	logrus.Infof("Refuse authentication of user %v from %v: locked out", ctx.User(), ctx.RemoteAddr())
+	return fmt.Errorf("locked out")
+}
+
+// recordLockout counts the result of password and keyboard-interactive attempts.
+// Rejected public keys are not counted, since clients usually offer several keys.
+func (s *server) recordLockout(ctx ssh.Context) func(*gossh.Permissions, error) (*gossh.Permissions, error) {
+	return func(perms *gossh.Permissions, err error) (*gossh.Permissions, error) {
+		if s.lockout == nil {
+			return perms, err
+		}
+		if _, partial := err.(*gossh.PartialSuccessError); partial {
+			return perms, err
+		}
+		if err == nil {
+			s.lockout.Success(ctx.RemoteAddr(), ctx.User())
+			return perms, err
+		}
+
+		delay := s.lockout.Failure(ctx.RemoteAddr(), ctx.User())
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		return perms, err
+	}
+}
+
+// secondFactor turns a successful first factor into a partial success,
+// which is completed by the keyboard-interactive challenge.
+func (s *server) secondFactor(ctx ssh.Context) func(*gossh.Permissions, error) (*gossh.Permissions, error) {
+	return func(perms *gossh.Permissions, err error) (*gossh.Permissions, error) {
+		if err != nil || s.challenge == nil || !s.challenge.Required(ctx) {
+			return perms, err
+		}
+		return nil, &gossh.PartialSuccessError{
+			Next: gossh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenger gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+					if err := s.checkLockout(ctx); err != nil {
+						return nil, err
+					}
+					if !s.challenge.Challenge(ctx, challenger) {
+						return s.recordLockout(ctx)(nil, fmt.Errorf("permission denied"))
+					}
+					return s.recordLockout(ctx)(perms, nil)
+				},
+			},
+		}
+	}
+}
+
+// applyAuthResult copies the result of the finished authentication into ctx.
+func (s *server) applyAuthResult(ctx ssh.Context) {
+	if ctx.Value(protocol.ContextKeyRoles) != nil {
+		return
+	}
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok || conn.Permissions == nil {
+		ctx.SetValue(protocol.ContextKeyRoles, []protocol.Role{})
+		return
+	}
+
+	if fingerprint := conn.Permissions.Extensions[permissionPublicKey]; fingerprint != "" {
+		if key, ok := ctx.Value(publicKeyContextKey{fingerprint}).(ssh.PublicKey); ok {
+			ctx.SetValue(ssh.ContextKeyPublicKey, key)
+		}
+	}
+
+	roles := make([]protocol.Role, 0)
+	if names := conn.Permissions.Extensions[permissionRoles]; names != "" {
+		for _, name := range strings.Split(names, ",") {
+			roles = append(roles, protocol.Role(name))
+		}
+	}
+	ctx.SetValue(protocol.ContextKeyReverseProxyAuthed, slices.Contains(roles, protocol.RoleReverseProxy))
+	ctx.SetValue(protocol.ContextKeyProxyAuthed, slices.Contains(roles, protocol.RoleProxy))
+	ctx.SetValue(protocol.ContextKeyRoles, roles)
+}
+
+// Roles returns the roles granted to the connection of ctx.
+func Roles(ctx ssh.Context) []protocol.Role {
+	roles, _ := ctx.Value(protocol.ContextKeyRoles).([]protocol.Role)
+	return roles
+}
+
+func (s *server) withAuthResult(h ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		s.applyAuthResult(ctx)
+		h(srv, conn, newChan, ctx)
+	}
+}
+
+func (s *server) withAuthResultRequest(h ssh.RequestHandler) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		s.applyAuthResult(ctx)
+		return h(ctx, srv, req)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ChallengeProvider asks for a second factor with keyboard-interactive
+// after password or public key authentication succeeded.
+type ChallengeProvider interface {
+	Required(ctx ssh.Context) bool
+	Challenge(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool
+}
+
+type OTPVerifier interface {
+	Enrolled(ctx context.Context, user string) bool
+	Verify(ctx context.Context, user, code string) bool
+}
+
+type otpChallenge struct {
+	verifier OTPVerifier
+	retries  int
+}
+
+// OTPChallenge prompts enrolled users for a one-time code, e.g. with auth.TOTP.
+func OTPChallenge(verifier OTPVerifier, retries int) ChallengeProvider {
+	return &otpChallenge{verifier: verifier, retries: max(retries, 1)}
+}
+
+func (c *otpChallenge) Required(ctx ssh.Context) bool {
+	return c.verifier.Enrolled(ctx, ctx.User())
+}
+
+func (c *otpChallenge) Challenge(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+	for i := 0; i < c.retries; i++ {
+		answers, err := challenger(ctx.User(), "", []string{"Verification code: "}, []bool{false})
+		if err != nil || len(answers) != 1 {
+			return false
+		}
+		if c.verifier.Verify(ctx, ctx.User(), answers[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/pigeonligh/srp/pkg/protocol"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type command func(s *server, sess ssh.Session, args []string)
+
+var commands = map[string]command{
+	"whoami":  (*server).whoamiCommand,
+	"targets": (*server).targetsCommand,
+}
+
+func (s *server) rolesString(ctx ssh.Context) string {
+	roles := Roles(ctx)
+	if len(roles) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *server) whoamiCommand(sess ssh.Session, args []string) {
+	ctx := sess.Context()
+	fmt.Fprintf(sess, "User: %v\n", sess.User())
+	fmt.Fprintf(sess, "Roles: %v\n", s.rolesString(ctx))
+	if key := sess.PublicKey(); key != nil {
+		fmt.Fprintf(sess, "Key: %v %v\n", key.Type(), gossh.FingerprintSHA256(key))
+	}
+}
+
+// targetsCommand lists the targets published by the caller only, as others' targets and errors are private.
+func (s *server) targetsCommand(sess ssh.Session, args []string) {
+	if s.rp == nil {
+		fmt.Fprintln(sess, "Reverse proxy is disabled")
+		return
+	}
+	if !slices.Contains(Roles(sess.Context()), protocol.RoleReverseProxy) {
+		fmt.Fprintln(sess, "Disallowed command")
+		return
+	}
+	for _, t := range s.rp.Targets() {
+		if t.User != sess.User() {
+			continue
+		}
+		line := fmt.Sprintf("%v/%v\t%v\t%v", t.Network, net.JoinHostPort(t.Host, t.Port), t.User, t.Health)
+		if t.LastError != nil {
+			line += fmt.Sprintf("\t%v", t.LastError)
+		}
+		fmt.Fprintln(sess, line)
+	}
+}
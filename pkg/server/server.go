@@ -8,6 +8,7 @@ import (
 	"github.com/charmbracelet/ssh"
 	"github.com/charmbracelet/wish"
 	"github.com/charmbracelet/wish/logging"
+	"github.com/pigeonligh/srp/pkg/auth"
 	"github.com/pigeonligh/srp/pkg/nets"
 	"github.com/pigeonligh/srp/pkg/proxy"
 	"github.com/pigeonligh/srp/pkg/reverseproxy"
@@ -26,6 +27,9 @@ type server struct {
 	h  ssh.Handler
 	l  net.Listener
 
+	challenge ChallengeProvider
+	lockout   *auth.Lockout
+
 	sshOptions []ssh.Option
 }
 
@@ -46,12 +50,16 @@ func (s *server) HandleSession(_ ssh.Handler) ssh.Handler {
 			return
 		}
 
-		if len(sess.Command()) > 0 {
-			fmt.Fprintln(sess, "Disallowed command")
+		if args := sess.Command(); len(args) > 0 {
+			if c, ok := commands[args[0]]; ok {
+				c(s, sess, args[1:])
+			} else {
+				fmt.Fprintln(sess, "Disallowed command")
+			}
 		} else {
 			_, _, isPty := sess.Pty()
 			if !isPty {
-				fmt.Fprintf(sess, "Welcome to %v, @%v!\n", s.name, sess.User())
+				fmt.Fprintf(sess, "Welcome to %v, @%v! Roles: %v\n", s.name, sess.User(), s.rolesString(sess.Context()))
 			} else {
 				fmt.Fprintln(sess, "PTY allocation request failed")
 			}
@@ -65,13 +73,23 @@ func (s *server) HandleSession(_ ssh.Handler) ssh.Handler {
 }
 
 func (s *server) Run(ctx context.Context) error {
+	if s.rp != nil && s.p != nil {
+		// Published and removed targets change what the proxy resolves them to.
+		s.rp.AddEventHandler(reverseproxy.EventHandler{
+			OnAdd:    s.p.InvalidateTarget,
+			OnRemove: s.p.InvalidateTarget,
+			OnHealthChange: func(host string, port string, healthy bool) {
+				s.p.InvalidateTarget(host, port)
+			},
+		})
+	}
+
 	options := make([]ssh.Option, 0)
 	options = append(options, s.sshOptions...)
 	options = append(options,
 		s.channelOption,
 		s.requestOption,
-		s.passwordOption,
-		s.publickeyOption,
+		s.authOption,
 		wish.WithMiddleware(
 			s.HandleSession,
 			logging.Middleware(),
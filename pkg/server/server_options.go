@@ -5,6 +5,7 @@ import (
 
 	"github.com/charmbracelet/ssh"
 	"github.com/charmbracelet/wish"
+	"github.com/pigeonligh/srp/pkg/auth"
 	"github.com/pigeonligh/srp/pkg/proxy"
 	"github.com/pigeonligh/srp/pkg/reverseproxy"
 )
@@ -46,3 +47,16 @@ func WithListener(l net.Listener) Option {
 		s.l = l
 	}
 }
+
+func WithChallengeProvider(c ChallengeProvider) Option {
+	return func(s *server) {
+		s.challenge = c
+	}
+}
+
+// WithLockout delays and bans clients after failed password or second factor attempts.
+func WithLockout(l *auth.Lockout) Option {
+	return func(s *server) {
+		s.lockout = l
+	}
+}
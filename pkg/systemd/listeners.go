@@ -0,0 +1,68 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Socket activation: https://www.freedesktop.org/software/systemd/man/latest/sd_listen_fds.html
+
+const listenFdsStart = 3
+
+type Listener struct {
+	net.Listener
+	Name string
+}
+
+// Listeners returns the listeners passed by systemd via LISTEN_FDS.
+// It returns nothing when the process is not socket activated.
+func Listeners(unsetEnv bool) ([]Listener, error) {
+	return listeners(listenFdsStart, unsetEnv)
+}
+
+func listeners(fdsStart int, unsetEnv bool) ([]Listener, error) {
+	if unsetEnv {
+		defer func() {
+			_ = os.Unsetenv("LISTEN_PID")
+			_ = os.Unsetenv("LISTEN_FDS")
+			_ = os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	ret := make([]Listener, 0, nfds)
+	for i := 0; i < nfds; i++ {
+		fd := fdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range ret {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("listener from fd %v (%v): %w", fd, name, err)
+		}
+		ret = append(ret, Listener{Listener: l, Name: name})
+	}
+	return ret, nil
+}
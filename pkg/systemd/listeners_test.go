@@ -0,0 +1,117 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// passListener hands a dup of l's fd over, like systemd does, and returns it.
+func passListener(t *testing.T, l *net.TCPListener) int {
+	t.Helper()
+	f, err := l.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+func TestListeners(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fd := passListener(t, l)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "ssh")
+
+	ls, err := listeners(fd, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 {
+		t.Fatalf("got %v listeners, want 1", len(ls))
+	}
+	defer ls[0].Close()
+	if ls[0].Name != "ssh" {
+		t.Errorf("got name %q, want %q", ls[0].Name, "ssh")
+	}
+	if ls[0].Addr().String() != l.Addr().String() {
+		t.Errorf("got address %v, want %v", ls[0].Addr(), l.Addr())
+	}
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(env); ok {
+			t.Errorf("%v is still set", env)
+		}
+	}
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	accepted, err := ls[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+}
+
+func TestListenersDefaultName(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fd := passListener(t, l)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "")
+
+	ls, err := listeners(fd, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls[0].Close()
+	if want := "LISTEN_FD_" + strconv.Itoa(fd); ls[0].Name != want {
+		t.Errorf("got name %q, want %q", ls[0].Name, want)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Errorf("LISTEN_FDS was unset")
+	}
+}
+
+func TestListenersNotActivated(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+		fds  string
+	}{
+		{"unset", "", ""},
+		{"other process", strconv.Itoa(os.Getpid() + 1), "1"},
+		{"no fds", strconv.Itoa(os.Getpid()), "0"},
+		{"invalid fds", strconv.Itoa(os.Getpid()), "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			ls, err := Listeners(false)
+			if err != nil || ls != nil {
+				t.Errorf("got %v, %v, want nothing", ls, err)
+			}
+		})
+	}
+}
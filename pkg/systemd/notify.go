@@ -0,0 +1,92 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notify protocol: https://www.freedesktop.org/software/systemd/man/latest/sd_notify.html
+
+const (
+	NotifyReady     = "READY=1"
+	NotifyStopping  = "STOPPING=1"
+	NotifyReloading = "RELOADING=1"
+	NotifyWatchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket in NOTIFY_SOCKET.
+// It returns false without error when no socket is configured.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, fmt.Errorf("dial notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("write notify socket: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout requested by systemd,
+// or zero when the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usecString := os.Getenv("WATCHDOG_USEC")
+	if usecString == "" {
+		return 0, nil
+	}
+	usec, err := strconv.ParseInt(usecString, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %v", usecString)
+	}
+
+	if pidString := os.Getenv("WATCHDOG_PID"); pidString != "" {
+		pid, err := strconv.Atoi(pidString)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WATCHDOG_PID: %v", pidString)
+		}
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// RunWatchdog keeps sending WATCHDOG=1 at half of the watchdog interval
+// until ctx is done.
+func RunWatchdog(ctx context.Context) error {
+	interval, err := WatchdogInterval()
+	if err != nil || interval == 0 {
+		return err
+	}
+	logrus.Infof("Systemd watchdog enabled with interval %v", interval)
+
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-t.C:
+			if _, err := Notify(NotifyWatchdog); err != nil {
+				logrus.Warnf("Cannot send watchdog keep-alive: %v", err)
+			}
+		}
+	}
+}
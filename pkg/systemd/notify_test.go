@@ -0,0 +1,118 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens where NOTIFY_SOCKET points, like systemd does.
+func fakeNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := fakeNotifySocket(t)
+
+	for _, state := range []string{NotifyReady, NotifyStopping} {
+		sent, err := Notify(state)
+		if err != nil || !sent {
+			t.Fatalf("Notify(%q) = %v, %v", state, sent, err)
+		}
+		if got := readNotification(t, conn); got != state {
+			t.Errorf("got %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(NotifyReady)
+	if sent || err != nil {
+		t.Errorf("got %v, %v, want false, nil", sent, err)
+	}
+}
+
+func TestNotifyMissingSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := Notify(NotifyReady); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		usec    string
+		pid     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"disabled", "", "", 0, false},
+		{"enabled", "3000000", "", 3 * time.Second, false},
+		{"this process", "1000", "self", time.Millisecond, false},
+		{"other process", "1000", "0", 0, false},
+		{"invalid", "soon", "", 0, true},
+		{"negative", "-1", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			if tt.pid == "self" {
+				t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+			} else {
+				t.Setenv("WATCHDOG_PID", tt.pid)
+			}
+
+			got, err := WatchdogInterval()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := fakeNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunWatchdog(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if got := readNotification(t, conn); got != NotifyWatchdog {
+			t.Errorf("got %q, want %q", got, NotifyWatchdog)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}